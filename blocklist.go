@@ -0,0 +1,264 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ima
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"encoding/hex"
+	"encoding/pem"
+
+	"crypto/sha256"
+	"crypto/x509"
+)
+
+var (
+	// This is returned when the key that made a Signature, or its
+	// Certificate, is in the Blocklist.
+	RevokedKey error = fmt.Errorf("ima: signing key has been revoked")
+
+	// This is returned when the digest of the file being verified is in the
+	// Blocklist.
+	RevokedDigest error = fmt.Errorf("ima: file digest has been revoked")
+)
+
+// Blocklist of revoked keys, Certificates and file digests, not unlike the
+// kernel's .blacklist keyring. When set in the VerifyOptions, Signatures
+// over a revoked digest, or made by a revoked key, will fail to verify.
+//
+// A Blocklist is safe for concurrent use.
+type Blocklist struct {
+	lock    sync.RWMutex
+	keyIds  map[[4]byte]bool
+	tbs     map[[sha256.Size]byte]bool
+	serials map[string]bool
+	digests map[string]bool
+}
+
+// Initialize an empty Blocklist.
+func NewBlocklist() *Blocklist {
+	return &Blocklist{
+		keyIds:  map[[4]byte]bool{},
+		tbs:     map[[sha256.Size]byte]bool{},
+		serials: map[string]bool{},
+		digests: map[string]bool{},
+	}
+}
+
+// Revoke all keys with the provided KeyId.
+func (b *Blocklist) AddKeyId(id [4]byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.keyIds[id] = true
+}
+
+// Revoke a Certificate. Like the kernel's "tbs:" entries, this matches the
+// SHA-256 hash of the to-be-signed part of the Certificate, so the same
+// Certificate re-signed by another CA isn't matched.
+func (b *Blocklist) AddCertificate(cert *x509.Certificate) {
+	b.addTBS(sha256.Sum256(cert.RawTBSCertificate))
+}
+
+func (b *Blocklist) addTBS(hash [sha256.Size]byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tbs[hash] = true
+}
+
+// Revoke a file digest. Like the kernel's "bin:" entries, this matches the
+// digest of the file, whatever Hash function it was computed with.
+func (b *Blocklist) AddDigest(digest []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.digests[string(digest)] = true
+}
+
+// Revoke every Certificate listed in the CRL. Certificates are matched by
+// their issuer and serial number.
+//
+// The signature on the CRL isn't checked here; use
+// x509.RevocationList.CheckSignatureFrom first if the CRL isn't already
+// trusted.
+func (b *Blocklist) AddCRL(crl *x509.RevocationList) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, entry := range crl.RevokedCertificateEntries {
+		b.serials[serialKey(crl.RawIssuer, entry.SerialNumber.Bytes())] = true
+	}
+}
+
+func serialKey(issuer, serial []byte) string {
+	return hex.EncodeToString(issuer) + ":" + hex.EncodeToString(serial)
+}
+
+// Check to see if keys with the KeyId have been revoked.
+func (b *Blocklist) BlocksKeyId(id [4]byte) bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.keyIds[id]
+}
+
+// Check to see if the Certificate has been revoked, either directly, or by
+// a CRL.
+func (b *Blocklist) BlocksCertificate(cert *x509.Certificate) bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if b.tbs[sha256.Sum256(cert.RawTBSCertificate)] {
+		return true
+	}
+	return b.serials[serialKey(cert.RawIssuer, cert.SerialNumber.Bytes())]
+}
+
+// Check to see if the file digest has been revoked.
+func (b *Blocklist) BlocksDigest(digest []byte) bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.digests[string(digest)]
+}
+
+// Read revocations from a simple text format, with one entry per line.
+// Blank lines, and lines starting with '#' are ignored. Each entry is a
+// type, followed by a colon and a hex string:
+//
+//	# revoked KeyId
+//	keyid:1a2b3c4d
+//	# SHA-256 of a revoked Certificate's TBSCertificate
+//	tbs:5f0c...
+//	# revoked file digest
+//	bin:e3b0...
+//
+// Entries are only added if the whole input parses.
+func (b *Blocklist) AddText(r io.Reader) error {
+	keyIds := [][4]byte{}
+	tbs := [][sha256.Size]byte{}
+	digests := [][]byte{}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("ima: blocklist line %d: expected type:hex", line)
+		}
+		value, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return fmt.Errorf("ima: blocklist line %d: %s", line, err)
+		}
+		switch parts[0] {
+		case "keyid":
+			if len(value) != 4 {
+				return fmt.Errorf("ima: blocklist line %d: keyid must be 4 bytes", line)
+			}
+			var id [4]byte
+			copy(id[:], value)
+			keyIds = append(keyIds, id)
+		case "tbs":
+			if len(value) != sha256.Size {
+				return fmt.Errorf("ima: blocklist line %d: tbs must be a SHA-256 hash", line)
+			}
+			var hash [sha256.Size]byte
+			copy(hash[:], value)
+			tbs = append(tbs, hash)
+		case "bin":
+			if len(value) == 0 {
+				return fmt.Errorf("ima: blocklist line %d: empty digest", line)
+			}
+			digests = append(digests, value)
+		default:
+			return fmt.Errorf("ima: blocklist line %d: unknown type %q", line, parts[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range keyIds {
+		b.AddKeyId(id)
+	}
+	for _, hash := range tbs {
+		b.addTBS(hash)
+	}
+	for _, digest := range digests {
+		b.AddDigest(digest)
+	}
+	return nil
+}
+
+// Load revocations from files, directories or globs, in the same way as
+// KeyPool.Load. Each file may be a PEM or DER encoded X.509 CRL, or the
+// text format read by AddText.
+func (b *Blocklist) Load(paths ...string) error {
+	return loadFiles(paths, func(path string) error {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(data, []byte("-----BEGIN X509 CRL")) {
+			crls := []*x509.RevocationList{}
+			for {
+				var block *pem.Block
+				block, data = pem.Decode(data)
+				if block == nil {
+					break
+				}
+				if block.Type != "X509 CRL" {
+					return fmt.Errorf("unknown PEM block type %q", block.Type)
+				}
+				crl, err := x509.ParseRevocationList(block.Bytes)
+				if err != nil {
+					return err
+				}
+				crls = append(crls, crl)
+			}
+			for _, crl := range crls {
+				b.AddCRL(crl)
+			}
+			return nil
+		}
+		if crl, err := x509.ParseRevocationList(data); err == nil {
+			b.AddCRL(crl)
+			return nil
+		}
+		return b.AddText(bytes.NewReader(data))
+	})
+}
+
+// Check a key that produced a valid Signature against the Blocklist. The
+// key is matched by both its PublicKeyId, and the CertificateKeyId of its
+// Certificate, if it has one.
+func (b *Blocklist) checkCandidate(candidate Candidate) error {
+	if id, err := PublicKeyId(candidate.Key); err == nil && b.BlocksKeyId(id) {
+		return RevokedKey
+	}
+	if candidate.Certificate == nil {
+		return nil
+	}
+	if id, err := CertificateKeyId(candidate.Certificate); err == nil && b.BlocksKeyId(id) {
+		return RevokedKey
+	}
+	if b.BlocksCertificate(candidate.Certificate) {
+		return RevokedKey
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ima_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"encoding/hex"
+	"encoding/pem"
+
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+
+	"pault.ag/go/ima"
+)
+
+func TestBlocklistVerify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	id, err := ima.PublicKeyId(key.Public())
+	isok(t, err)
+
+	pool := ima.NewKeyPool()
+	isok(t, pool.AddKey(key.Public()))
+
+	digest := sha256.Sum256([]byte("totally legit elf af"))
+	sigBytes, err := ima.Sign(key, rand.Reader, digest[:], ima.SHA256.Hash)
+	isok(t, err)
+	sig, err := ima.Parse(sigBytes)
+	isok(t, err)
+
+	opts := ima.VerifyOptions{
+		Digest:    digest[:],
+		Hash:      ima.SHA256.Hash,
+		Keys:      pool,
+		Blocklist: ima.NewBlocklist(),
+	}
+	_, err = sig.Verify(opts)
+	isok(t, err)
+
+	opts.Blocklist.AddDigest(digest[:])
+	_, err = sig.Verify(opts)
+	assert(t, errors.Is(err, ima.RevokedDigest))
+
+	opts.Blocklist = ima.NewBlocklist()
+	opts.Blocklist.AddKeyId(id)
+	_, err = sig.Verify(opts)
+	assert(t, errors.Is(err, ima.RevokedKey))
+}
+
+func TestBlocklistCertificate(t *testing.T) {
+	root := newTestCA(t, nil, "root")
+	leaf := newTestCert(t, root, x509.Certificate{
+		Subject:  pkix.Name{CommonName: "leaf"},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	})
+	other := newTestCert(t, root, x509.Certificate{
+		Subject:  pkix.Name{CommonName: "other"},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	})
+
+	pool := ima.NewKeyPool()
+	isok(t, pool.AddCertificate(leaf.cert))
+
+	digest := sha256.Sum256([]byte("totally legit elf af"))
+	sigBytes, err := ima.Sign(leaf.key, rand.Reader, digest[:], ima.SignatureOptions{
+		Hash:        ima.SHA256,
+		Certificate: leaf.cert,
+	})
+	isok(t, err)
+	sig, err := ima.Parse(sigBytes)
+	isok(t, err)
+
+	blocklist := ima.NewBlocklist()
+	opts := ima.VerifyOptions{
+		Digest:    digest[:],
+		Hash:      ima.SHA256.Hash,
+		Keys:      pool,
+		Blocklist: blocklist,
+	}
+	_, err = sig.Verify(opts)
+	isok(t, err)
+
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now(),
+		NextUpdate: time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: leaf.cert.SerialNumber, RevocationTime: time.Now()},
+		},
+	}, root.cert, root.key)
+	isok(t, err)
+
+	dir, err := ioutil.TempDir("", "ima")
+	isok(t, err)
+	defer os.RemoveAll(dir)
+	isok(t, ioutil.WriteFile(
+		filepath.Join(dir, "root.crl"),
+		pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crlDER}),
+		0644,
+	))
+	isok(t, blocklist.Load(filepath.Join(dir, "*.crl")))
+
+	assert(t, blocklist.BlocksCertificate(leaf.cert))
+	assert(t, !blocklist.BlocksCertificate(other.cert))
+	_, err = sig.Verify(opts)
+	assert(t, errors.Is(err, ima.RevokedKey))
+
+	blocklist = ima.NewBlocklist()
+	blocklist.AddCertificate(leaf.cert)
+	assert(t, blocklist.BlocksCertificate(leaf.cert))
+	assert(t, !blocklist.BlocksCertificate(other.cert))
+}
+
+func TestBlocklistText(t *testing.T) {
+	root := newTestCA(t, nil, "root")
+	tbs := sha256.Sum256(root.cert.RawTBSCertificate)
+
+	blocklist := ima.NewBlocklist()
+	isok(t, blocklist.AddText(strings.NewReader(fmt.Sprintf(`
+# comments and blank lines are fine
+
+keyid:01020304
+tbs:%s
+bin:%s
+`, hex.EncodeToString(tbs[:]), "e3b0c44298fc1c149afbf4c8996fb924"))))
+
+	assert(t, blocklist.BlocksKeyId([4]byte{1, 2, 3, 4}))
+	assert(t, !blocklist.BlocksKeyId([4]byte{1, 2, 3, 5}))
+	assert(t, blocklist.BlocksCertificate(root.cert))
+	digest, err := hex.DecodeString("e3b0c44298fc1c149afbf4c8996fb924")
+	isok(t, err)
+	assert(t, blocklist.BlocksDigest(digest))
+
+	blocklist = ima.NewBlocklist()
+	for _, bad := range []string{
+		"keyid:0102",
+		"tbs:0102",
+		"bin:zz",
+		"bin:",
+		"nope:0102",
+		"0102",
+	} {
+		notok(t, blocklist.AddText(strings.NewReader("keyid:01020304\n"+bad)))
+	}
+	assert(t, !blocklist.BlocksKeyId([4]byte{1, 2, 3, 4}))
+}
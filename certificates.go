@@ -0,0 +1,144 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ima
+
+import (
+	"fmt"
+	"time"
+
+	"crypto/x509"
+)
+
+// How Verify treats the Certificate of a key that has produced a valid
+// Signature.
+type CertificatePolicy uint8
+
+const (
+	// Don't check Certificates at all. This is the default.
+	CertificatesIgnore CertificatePolicy = iota
+
+	// Check Certificates, and pass any problems to VerifyOptions.Warn, but
+	// still accept the Signature.
+	CertificatesWarn
+
+	// Check Certificates, and reject Signatures made by keys whose
+	// Certificate fails a check.
+	CertificatesEnforce
+)
+
+var (
+	// The Certificate's NotAfter is before the time of verification.
+	CertificateExpired error = fmt.Errorf("ima: certificate has expired")
+
+	// The Certificate's NotBefore is after the time of verification.
+	CertificateNotYetValid error = fmt.Errorf("ima: certificate is not yet valid")
+
+	// The Certificate has a keyUsage extension without digitalSignature.
+	CertificateKeyUsage error = fmt.Errorf("ima: certificate keyUsage does not allow digitalSignature")
+
+	// The Certificate has an extKeyUsage extension without any of the
+	// allowed usages.
+	CertificateExtKeyUsage error = fmt.Errorf("ima: certificate extKeyUsage is not allowed")
+)
+
+// Error for a Certificate that failed one of the checks made by Verify. The
+// Constraint is one of CertificateExpired, CertificateNotYetValid,
+// CertificateKeyUsage or CertificateExtKeyUsage, and can be checked for with
+// errors.Is.
+type CertificateError struct {
+	Certificate *x509.Certificate
+	Constraint  error
+}
+
+func (e CertificateError) Error() string {
+	return fmt.Sprintf("%s: %q", e.Constraint, e.Certificate.Subject)
+}
+
+func (e CertificateError) Unwrap() error {
+	return e.Constraint
+}
+
+// Check the Certificate against the VerifyOptions, returning a
+// CertificateError for the first check that fails.
+//
+// The Certificate must be valid at CurrentTime, or now if that's not set.
+// If the Certificate has a keyUsage extension, it must allow
+// digitalSignature. If it has an extKeyUsage extension, it must contain
+// one of ExtKeyUsages, or x509.ExtKeyUsageCodeSigning if that's not set;
+// x509.ExtKeyUsageAny in either list matches everything.
+func (o VerifyOptions) CheckCertificate(cert *x509.Certificate) error {
+	now := o.CurrentTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if now.Before(cert.NotBefore) {
+		return CertificateError{Certificate: cert, Constraint: CertificateNotYetValid}
+	}
+	if now.After(cert.NotAfter) {
+		return CertificateError{Certificate: cert, Constraint: CertificateExpired}
+	}
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return CertificateError{Certificate: cert, Constraint: CertificateKeyUsage}
+	}
+	if len(cert.ExtKeyUsage) != 0 || len(cert.UnknownExtKeyUsage) != 0 {
+		allowed := o.ExtKeyUsages
+		if len(allowed) == 0 {
+			allowed = []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+		}
+		if !extKeyUsageAllowed(cert.ExtKeyUsage, allowed) {
+			return CertificateError{Certificate: cert, Constraint: CertificateExtKeyUsage}
+		}
+	}
+	return nil
+}
+
+func extKeyUsageAllowed(usages, allowed []x509.ExtKeyUsage) bool {
+	for _, allow := range allowed {
+		if allow == x509.ExtKeyUsageAny {
+			return true
+		}
+		for _, usage := range usages {
+			if usage == allow || usage == x509.ExtKeyUsageAny {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check a key that produced a valid Signature against the Blocklist, and
+// apply the CertificatePolicy to it. Keys that were added without a
+// Certificate always pass the CertificatePolicy.
+func (o VerifyOptions) checkCandidate(candidate Candidate) error {
+	if o.Blocklist != nil {
+		if err := o.Blocklist.checkCandidate(candidate); err != nil {
+			return err
+		}
+	}
+	if o.CertificatePolicy == CertificatesIgnore || candidate.Certificate == nil {
+		return nil
+	}
+	err := o.CheckCertificate(candidate.Certificate)
+	if err == nil {
+		return nil
+	}
+	if o.CertificatePolicy == CertificatesWarn {
+		if o.Warn != nil {
+			o.Warn(err)
+		}
+		return nil
+	}
+	return err
+}
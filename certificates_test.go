@@ -0,0 +1,143 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ima_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+
+	"pault.ag/go/ima"
+)
+
+func TestVerifyCertificatePolicy(t *testing.T) {
+	root := newTestCA(t, nil, "root")
+	now := time.Now()
+
+	for _, test := range []struct {
+		Name       string
+		Template   x509.Certificate
+		Constraint error
+	}{
+		{
+			Name: "valid",
+			Template: x509.Certificate{
+				KeyUsage:    x509.KeyUsageDigitalSignature,
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+			},
+		},
+		{
+			Name: "expired",
+			Template: x509.Certificate{
+				NotBefore: now.Add(-2 * time.Hour),
+				NotAfter:  now.Add(-time.Hour),
+			},
+			Constraint: ima.CertificateExpired,
+		},
+		{
+			Name: "not yet valid",
+			Template: x509.Certificate{
+				NotBefore: now.Add(time.Hour),
+				NotAfter:  now.Add(2 * time.Hour),
+			},
+			Constraint: ima.CertificateNotYetValid,
+		},
+		{
+			Name:       "key usage",
+			Template:   x509.Certificate{KeyUsage: x509.KeyUsageKeyEncipherment},
+			Constraint: ima.CertificateKeyUsage,
+		},
+		{
+			Name: "ext key usage",
+			Template: x509.Certificate{
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			},
+			Constraint: ima.CertificateExtKeyUsage,
+		},
+	} {
+		test.Template.Subject = pkix.Name{CommonName: test.Name}
+		leaf := newTestCert(t, root, test.Template)
+
+		pool := ima.NewKeyPool()
+		isok(t, pool.AddCertificate(leaf.cert))
+
+		digest := sha256.Sum256([]byte(test.Name))
+		sigBytes, err := ima.Sign(leaf.key, rand.Reader, digest[:], ima.SignatureOptions{
+			Hash:        ima.SHA256,
+			Certificate: leaf.cert,
+		})
+		isok(t, err)
+		sig, err := ima.Parse(sigBytes)
+		isok(t, err)
+
+		opts := ima.VerifyOptions{
+			Digest: digest[:],
+			Hash:   ima.SHA256.Hash,
+			Keys:   pool,
+		}
+
+		// Certificates are ignored by default.
+		_, err = sig.Verify(opts)
+		isok(t, err)
+
+		opts.CertificatePolicy = ima.CertificatesEnforce
+		_, err = sig.Verify(opts)
+		if test.Constraint == nil {
+			isok(t, err)
+		} else {
+			assert(t, errors.Is(err, test.Constraint))
+			var certErr ima.CertificateError
+			assert(t, errors.As(err, &certErr))
+			assert(t, certErr.Certificate == leaf.cert)
+		}
+
+		warnings := []error{}
+		opts.CertificatePolicy = ima.CertificatesWarn
+		opts.Warn = func(err error) { warnings = append(warnings, err) }
+		_, err = sig.Verify(opts)
+		isok(t, err)
+		if test.Constraint == nil {
+			assert(t, len(warnings) == 0)
+		} else {
+			assert(t, len(warnings) == 1)
+			assert(t, errors.Is(warnings[0], test.Constraint))
+		}
+	}
+}
+
+func TestCheckCertificateOptions(t *testing.T) {
+	root := newTestCA(t, nil, "root")
+	leaf := newTestCert(t, root, x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+
+	opts := ima.VerifyOptions{}
+	assert(t, errors.Is(opts.CheckCertificate(leaf.cert), ima.CertificateExtKeyUsage))
+
+	opts.ExtKeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	isok(t, opts.CheckCertificate(leaf.cert))
+
+	opts.ExtKeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	isok(t, opts.CheckCertificate(leaf.cert))
+
+	opts.CurrentTime = leaf.cert.NotAfter.Add(time.Second)
+	assert(t, errors.Is(opts.CheckCertificate(leaf.cert), ima.CertificateExpired))
+}
@@ -0,0 +1,297 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"encoding/hex"
+
+	"crypto"
+	"crypto/rand"
+
+	"github.com/urfave/cli"
+
+	"pault.ag/go/ima"
+	"pault.ag/go/ima/tlog"
+)
+
+// Flag to record every signature made in a transparency log.
+var LogFlag = cli.StringFlag{
+	Name:  "log",
+	Usage: "append every signature made to this transparency log file",
+}
+
+// Open the --log file for appending, or return nil if it's not set.
+func openLog(c *cli.Context) (*tlog.Log, error) {
+	if c.String("log") == "" {
+		return nil, nil
+	}
+	return tlog.Open(c.String("log"))
+}
+
+// Sign the digest, and record it in the log, if there is one.
+func logSign(log *tlog.Log, signer crypto.Signer, digest []byte, opts ima.SignatureOptions, path string) ([]byte, error) {
+	if log == nil {
+		return ima.Sign(signer, rand.Reader, digest, opts)
+	}
+	return log.Sign(signer, rand.Reader, digest, opts, path)
+}
+
+// Load the --log file named by the log subcommands, which must exist.
+func loadLog(c *cli.Context) (*tlog.Log, error) {
+	if c.String("log") == "" {
+		return nil, fmt.Errorf("imactl: --log is needed")
+	}
+	return tlog.Load(c.String("log"))
+}
+
+// Parse a log size and hex root hash, as printed by log root.
+func parseRoot(sizeArg, rootArg string) (uint64, tlog.NodeHash, error) {
+	size, err := strconv.ParseUint(sizeArg, 10, 64)
+	if err != nil {
+		return 0, tlog.NodeHash{}, fmt.Errorf("imactl: bad size %q", sizeArg)
+	}
+	rootBytes, err := hex.DecodeString(rootArg)
+	if err != nil || len(rootBytes) != len(tlog.NodeHash{}) {
+		return 0, tlog.NodeHash{}, fmt.Errorf("imactl: bad root %q", rootArg)
+	}
+	root := tlog.NodeHash{}
+	copy(root[:], rootBytes)
+	return size, root, nil
+}
+
+// Size and root of the log that the log verify subcommand checks against,
+// which the log file itself can't vouch for.
+type trustedRoot struct {
+	Size uint64
+	Root tlog.NodeHash
+}
+
+// Read the trusted --size and --root, or return nil if neither is set.
+func loadTrustedRoot(c *cli.Context) (*trustedRoot, error) {
+	if c.String("size") == "" && c.String("root") == "" {
+		return nil, nil
+	}
+	if c.String("size") == "" || c.String("root") == "" {
+		return nil, fmt.Errorf("imactl: --size and --root must be given together")
+	}
+	size, root, err := parseRoot(c.String("size"), c.String("root"))
+	if err != nil {
+		return nil, err
+	}
+	return &trustedRoot{Size: size, Root: root}, nil
+}
+
+// Check that a serialized signature is in the log, by finding it, and
+// checking an inclusion proof against the trusted root. Without a trusted
+// root, the log can only vouch for itself, so the entry is printed without
+// claiming it was verified. If showProof is set, the inclusion proof is
+// printed too.
+func checkLogged(log *tlog.Log, name string, value []byte, trusted *trustedRoot, showProof bool) error {
+	index, err := log.Find(value)
+	if err != nil {
+		return fmt.Errorf("imactl: %s: %w", name, err)
+	}
+	entry, err := log.Entry(index)
+	if err != nil {
+		return err
+	}
+	leaf, err := log.LeafHash(index)
+	if err != nil {
+		return err
+	}
+	when := entry.Time.Format("2006-01-02T15:04:05Z07:00")
+
+	if trusted == nil {
+		size, root := log.Root()
+		proof, err := log.InclusionProof(index, size)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: UNVERIFIED: found as entry %d at %s, in a log of size %d with root %x; "+
+			"give a trusted --size and --root to verify it\n",
+			name, index, when, size, root)
+		if showProof {
+			printProof(os.Stdout, proof)
+		}
+		return nil
+	}
+
+	if index >= trusted.Size {
+		return fmt.Errorf("imactl: %s: not logged as of the trusted size %d", name, trusted.Size)
+	}
+	proof, err := log.InclusionProof(index, trusted.Size)
+	if err != nil {
+		return fmt.Errorf("imactl: %s: %w", name, err)
+	}
+	if err := tlog.VerifyInclusion(leaf, index, trusted.Size, proof, trusted.Root); err != nil {
+		return fmt.Errorf("imactl: %s: %w", name, err)
+	}
+	fmt.Printf("%s: logged as entry %d at %s, in the log of size %d with root %x\n",
+		name, index, when, trusted.Size, trusted.Root)
+	if showProof {
+		printProof(os.Stdout, proof)
+	}
+	return nil
+}
+
+func LogVerify(c *cli.Context) error {
+	trusted, err := loadTrustedRoot(c)
+	if err != nil {
+		return err
+	}
+	log, err := loadLog(c)
+	if err != nil {
+		return err
+	}
+
+	if c.String("value") != "" {
+		value, err := hex.DecodeString(c.String("value"))
+		if err != nil {
+			return fmt.Errorf("imactl: --value is not hex")
+		}
+		return checkLogged(log, "value", value, trusted, c.Bool("proof"))
+	}
+
+	stores, err := signatureStores(c)
+	if err != nil {
+		return err
+	}
+	for _, path := range c.Args() {
+		fd, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		for _, store := range stores {
+			name := fmt.Sprintf("%s (%s)", path, store)
+			value, err := store.Get(fd)
+			if err != nil {
+				return fmt.Errorf("imactl: %s: %w", name, err)
+			}
+			if err := checkLogged(log, name, value, trusted, c.Bool("proof")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func LogRoot(c *cli.Context) error {
+	log, err := loadLog(c)
+	if err != nil {
+		return err
+	}
+	size, root := log.Root()
+	fmt.Printf("%d %x\n", size, root)
+	return nil
+}
+
+func LogConsistency(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("imactl: log consistency needs the old size and root")
+	}
+	oldSize, oldRoot, err := parseRoot(c.Args()[0], c.Args()[1])
+	if err != nil {
+		return err
+	}
+
+	log, err := loadLog(c)
+	if err != nil {
+		return err
+	}
+	size, root := log.Root()
+	proof, err := log.ConsistencyProof(oldSize, size)
+	if err != nil {
+		return err
+	}
+	if err := tlog.VerifyConsistency(oldSize, size, proof, oldRoot, root); err != nil {
+		return fmt.Errorf("imactl: the log isn't an extension of the one of size %d: %w", oldSize, err)
+	}
+	fmt.Printf("%d %x\n", size, root)
+	if c.Bool("proof") {
+		printProof(os.Stdout, proof)
+	}
+	return nil
+}
+
+func printProof(w io.Writer, proof []tlog.NodeHash) {
+	for _, node := range proof {
+		fmt.Fprintf(w, "%x\n", node)
+	}
+}
+
+var logFileFlag = cli.StringFlag{
+	Name:  "log",
+	Usage: "transparency log file",
+}
+
+var LogCommand = cli.Command{
+	Name:  "log",
+	Usage: "audit the transparency log of signatures",
+	Subcommands: []cli.Command{
+		{
+			Name:      "verify",
+			Action:    Wrapper(LogVerify),
+			Usage:     "check the signatures on files were logged, in a log with a trusted size and root",
+			ArgsUsage: "FILE...",
+			Flags: []cli.Flag{
+				logFileFlag,
+				StoreFlag,
+				cli.StringFlag{
+					Name:  "size",
+					Usage: "trusted size of the log, as printed by log root; needs --root",
+				},
+				cli.StringFlag{
+					Name:  "root",
+					Usage: "trusted hex root hash of the log at --size, as printed by log root",
+				},
+				cli.StringFlag{
+					Name:  "value",
+					Usage: "hex security.ima value to check, rather than reading it from files",
+				},
+				cli.BoolFlag{
+					Name:  "proof",
+					Usage: "print the inclusion proof, one hash per line",
+				},
+			},
+		},
+		{
+			Name:   "root",
+			Action: Wrapper(LogRoot),
+			Usage:  "print the size and root hash of the log",
+			Flags:  []cli.Flag{logFileFlag},
+		},
+		{
+			Name:      "consistency",
+			Action:    Wrapper(LogConsistency),
+			Usage:     "check the log has only been appended to since it had an old size and root",
+			ArgsUsage: "OLD-SIZE OLD-ROOT",
+			Flags: []cli.Flag{
+				logFileFlag,
+				cli.BoolFlag{
+					Name:  "proof",
+					Usage: "print the consistency proof, one hash per line",
+				},
+			},
+		},
+	},
+}
+
+// vim: foldmethod=marker
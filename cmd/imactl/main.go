@@ -15,67 +15,124 @@
 package main
 
 import (
-	"encoding/pem"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 
 	"crypto"
-	"crypto/x509"
 
 	"github.com/urfave/cli"
 
 	"pault.ag/go/ima"
+	"pault.ag/go/ima/pkcs11"
+	"pault.ag/go/ima/xattr"
 )
 
 func LoadPool(c *cli.Context) (*ima.KeyPool, error) {
-	fd, err := os.Open(c.GlobalString("pubkey"))
-	if err != nil {
-		return nil, err
+	paths := c.GlobalStringSlice("pubkey")
+	if len(paths) == 0 {
+		paths = []string{"/etc/keys/pubkey_evm.pem"}
 	}
-	defer fd.Close()
-	data, err := ioutil.ReadAll(fd)
+
+	pool := ima.NewKeyPool()
+	anchors, err := LoadTrustAnchors(c)
 	if err != nil {
 		return nil, err
 	}
+	if anchors != nil {
+		pool = ima.NewTrustedKeyPool(anchors)
+	}
 
-	pool := ima.NewKeyPool()
-
-	for {
-		if len(data) == 0 {
-			break
-		}
-		var block *pem.Block
-		block, data = pem.Decode(data)
-		key, err := x509.ParsePKIXPublicKey(block.Bytes)
-		if err != nil {
+	if err := pool.Load(paths...); err != nil {
+		loadErrs, ok := err.(ima.LoadErrors)
+		if !ok {
 			return nil, err
 		}
-		if err := pool.AddKey(key); err != nil {
-			return nil, err
+		for _, loadErr := range loadErrs {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", loadErr)
 		}
 	}
-
+	if pool.Len() == 0 {
+		return nil, fmt.Errorf("imactl: no public keys could be loaded")
+	}
 	return &pool, nil
 }
 
-func LoadSigner(c *cli.Context) (crypto.Signer, error) {
-	fd, err := os.Open(c.GlobalString("privkey"))
+// Load the CAs given with --ca, or return nil if there weren't any, and
+// every key should be trusted.
+func LoadTrustAnchors(c *cli.Context) (*ima.TrustAnchors, error) {
+	cas := c.GlobalStringSlice("ca")
+	if len(cas) == 0 {
+		return nil, nil
+	}
+	anchors, err := ima.NewTrustAnchors()
 	if err != nil {
 		return nil, err
 	}
-	defer fd.Close()
-	data, err := ioutil.ReadAll(fd)
+	if err := anchors.Load(cas...); err != nil {
+		return nil, err
+	}
+	return anchors, nil
+}
+
+func LoadSigner(c *cli.Context) (crypto.Signer, error) {
+	return loadSigner(c.GlobalString("privkey"), passphraseSource(c))
+}
+
+// Load a private key file, or open a key in a token if path is a pkcs11:
+// URI. See parsePrivateKey for the file formats that are understood.
+//
+// Keys in a token hold the token open, so the Signer must be passed to
+// closeSigner when it's no longer needed.
+func loadSigner(path string, passphrase passphraseFunc) (crypto.Signer, error) {
+	if pkcs11.IsURI(path) {
+		return pkcs11.Open(path)
+	}
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	block, _ := pem.Decode(data)
-	return x509.ParsePKCS1PrivateKey(block.Bytes)
+	return parsePrivateKey(path, data, passphrase)
+}
+
+// Close the Signer, if it's something like a *pkcs11.Signer that holds
+// resources open, such as a session with a token.
+func closeSigner(signer crypto.Signer) error {
+	if closer, ok := signer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Flag to pick where signatures are read from and written to.
+var StoreFlag = cli.StringFlag{
+	Name:  "store",
+	Usage: "where signatures are kept: xattr (security.ima), user (user.ima), sidecar (a detached file.sig) or both (xattr and sidecar)",
+	Value: "xattr",
+}
+
+// Parse the StoreFlag into the xattr.Stores to use.
+func signatureStores(c *cli.Context) ([]xattr.Store, error) {
+	switch c.String("store") {
+	case "xattr":
+		return []xattr.Store{xattr.IMA}, nil
+	case "user":
+		return []xattr.Store{xattr.UserIMA}, nil
+	case "sidecar":
+		return []xattr.Store{xattr.Sidecar}, nil
+	case "both":
+		return []xattr.Store{xattr.IMA, xattr.Sidecar}, nil
+	default:
+		return nil, fmt.Errorf("imactl: unknown --store %q", c.String("store"))
+	}
 }
 
 func Wrapper(cmd func(*cli.Context) error) func(*cli.Context) error {
 	return func(c *cli.Context) error {
 		if err := cmd(c); err != nil {
-			panic(err)
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
 		}
 		return nil
 	}
@@ -88,19 +145,31 @@ func main() {
 	app.Version = "0.1"
 
 	app.Flags = []cli.Flag{
-		cli.StringFlag{
+		cli.StringSliceFlag{
 			Name:  "pubkey",
-			Value: "/etc/keys/pubkey_evm.pem",
+			Usage: "file, directory or glob of public keys and certificates (default: /etc/keys/pubkey_evm.pem)",
+		},
+		cli.StringSliceFlag{
+			Name:  "ca",
+			Usage: "only trust public keys in certificates issued by these CA certificates (repeatable)",
 		},
 		cli.StringFlag{
 			Name:  "privkey",
+			Usage: "private key file (PEM or DER; PKCS#1, PKCS#8 or SEC1), or a pkcs11: URI for a key in a token",
 			Value: "/etc/keys/privkey_evm.pem",
 		},
+		cli.StringFlag{
+			Name:  "passphrase-file",
+			Usage: "file with the passphrase of an encrypted --privkey (default: $IMACTL_PASSPHRASE, or ask)",
+		},
 	}
 
 	app.Commands = []cli.Command{
 		SignCommand,
 		VerifyCommand,
+		ServeCommand,
+		ManifestCommand,
+		LogCommand,
 	}
 
 	app.Run(os.Args)
@@ -0,0 +1,181 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/urfave/cli"
+
+	"pault.ag/go/ima"
+	"pault.ag/go/ima/manifest"
+	"pault.ag/go/ima/tlog"
+)
+
+// Open the --output file, or stdout if it's not set.
+func openOutput(c *cli.Context) (io.WriteCloser, error) {
+	if c.String("output") == "" || c.String("output") == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(c.String("output"))
+}
+
+// Read the Manifest named by the first argument, or stdin if there isn't one.
+func readManifest(c *cli.Context) (manifest.Manifest, error) {
+	if c.NArg() == 0 || c.Args().First() == "-" {
+		return manifest.Parse(os.Stdin)
+	}
+	fd, err := os.Open(c.Args().First())
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return manifest.Parse(fd)
+}
+
+func writeManifest(c *cli.Context, m manifest.Manifest) error {
+	out, err := openOutput(c)
+	if err != nil {
+		return err
+	}
+	if err := m.Write(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func ManifestDigest(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("imactl: manifest digest needs the root of the tree")
+	}
+	hash, err := ima.HashFunctions.ByName(c.String("hash"))
+	if err != nil {
+		return err
+	}
+	m, err := manifest.Walk(c.Args().First(), *hash)
+	if err != nil {
+		return err
+	}
+	return writeManifest(c, m)
+}
+
+func ManifestSign(c *cli.Context) error {
+	m, err := readManifest(c)
+	if err != nil {
+		return err
+	}
+	signer, err := loadSignCommandSigner(c)
+	if err != nil {
+		return err
+	}
+	defer closeSigner(signer)
+	signed, err := m.Sign(signer, rand.Reader, ima.SignatureOptions{})
+	if err != nil {
+		return err
+	}
+
+	log, err := openLog(c)
+	if err != nil {
+		return err
+	}
+	if log != nil {
+		defer log.Close()
+		for _, entry := range signed {
+			logEntry, err := tlog.NewEntry(entry.Signature, entry.Digest, entry.Path)
+			if err != nil {
+				return err
+			}
+			if _, err := log.Append(*logEntry); err != nil {
+				return err
+			}
+		}
+	}
+	return writeManifest(c, signed)
+}
+
+func ManifestApply(c *cli.Context) error {
+	stores, err := signatureStores(c)
+	if err != nil {
+		return err
+	}
+	m, err := readManifest(c)
+	if err != nil {
+		return err
+	}
+	return m.Apply(c.String("root"), func(fd *os.File, signature []byte) error {
+		if _, err := ima.ParseXattr(signature); err != nil {
+			return err
+		}
+		for _, store := range stores {
+			if err := store.Set(fd, signature); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+var outputFlag = cli.StringFlag{
+	Name:  "output",
+	Usage: "file to write the manifest to (default: stdout)",
+}
+
+var ManifestCommand = cli.Command{
+	Name:  "manifest",
+	Usage: "sign files offline, through a manifest of their digests",
+	Subcommands: []cli.Command{
+		{
+			Name:      "digest",
+			Action:    Wrapper(ManifestDigest),
+			Usage:     "measure every file in a tree into a manifest",
+			ArgsUsage: "ROOT",
+			Flags: []cli.Flag{
+				outputFlag,
+				cli.StringFlag{
+					Name:  "hash",
+					Usage: "hash algorithm to measure files with",
+					Value: "sha256",
+				},
+			},
+		},
+		{
+			Name:      "sign",
+			Action:    Wrapper(ManifestSign),
+			Usage:     "sign the digests in a manifest, without the files",
+			ArgsUsage: "[MANIFEST]",
+			Flags:     append([]cli.Flag{outputFlag, LogFlag}, remoteFlags...),
+		},
+		{
+			Name:      "apply",
+			Action:    Wrapper(ManifestApply),
+			Usage:     "set the signatures in a signed manifest on a tree",
+			ArgsUsage: "[MANIFEST]",
+			Flags: []cli.Flag{
+				StoreFlag,
+				cli.StringFlag{
+					Name:  "root",
+					Usage: "root of the tree the manifest was made from",
+					Value: ".",
+				},
+			},
+		},
+	},
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,196 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"encoding/asn1"
+	"encoding/pem"
+
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+
+	"github.com/urfave/cli"
+	"github.com/youmark/pkcs8"
+	"golang.org/x/term"
+)
+
+// Get the passphrase for the encrypted private key at path.
+type passphraseFunc func(path string) ([]byte, error)
+
+// Return a passphraseFunc that reads the passphrase from --passphrase-file,
+// or $IMACTL_PASSPHRASE, or failing that, prompts for it on the terminal.
+func passphraseSource(c *cli.Context) passphraseFunc {
+	return func(path string) ([]byte, error) {
+		if file := c.GlobalString("passphrase-file"); file != "" {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("reading the passphrase: %w", err)
+			}
+			return bytes.TrimRight(data, "\r\n"), nil
+		}
+		if passphrase, ok := os.LookupEnv("IMACTL_PASSPHRASE"); ok {
+			return []byte(passphrase), nil
+		}
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, fmt.Errorf("key is encrypted, and there's no terminal to ask for the passphrase on; use --passphrase-file or $IMACTL_PASSPHRASE")
+		}
+		fmt.Fprintf(os.Stderr, "Passphrase for %s: ", path)
+		defer fmt.Fprintln(os.Stderr)
+		return term.ReadPassword(int(os.Stdin.Fd()))
+	}
+}
+
+// Parse a private key file, which may be PEM or DER encoded PKCS#1, PKCS#8
+// or SEC1, and may be encrypted. passphrase is only called if it is.
+func parsePrivateKey(path string, data []byte, passphrase passphraseFunc) (crypto.Signer, error) {
+	var (
+		key interface{}
+		err error
+	)
+	if bytes.Contains(data, []byte("-----BEGIN ")) {
+		key, err = parsePrivateKeyPEM(path, data, passphrase)
+	} else {
+		key, err = parsePrivateKeyDER(path, data, passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("imactl: %s: %w", path, err)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("imactl: %s: %T keys can't make IMA signatures", path, key)
+	}
+}
+
+// Parse the first private key in a PEM file, skipping anything else, such as
+// the EC PARAMETERS block openssl writes before an EC key.
+func parsePrivateKeyPEM(path string, data []byte, passphrase passphraseFunc) (interface{}, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no private key PEM block found")
+		}
+
+		der := block.Bytes
+		// Legacy "Proc-Type: 4,ENCRYPTED" PEM is deprecated, since it isn't
+		// authenticated, but plenty of keys are still around in it.
+		if x509.IsEncryptedPEMBlock(block) {
+			password, err := passphrase(path)
+			if err != nil {
+				return nil, err
+			}
+			der, err = x509.DecryptPEMBlock(block, password)
+			if errors.Is(err, x509.IncorrectPasswordError) {
+				return nil, fmt.Errorf("wrong passphrase")
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		switch block.Type {
+		case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY", "ENCRYPTED PRIVATE KEY":
+			if err := checkTrailingData(der); err != nil {
+				return nil, err
+			}
+		}
+
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(der)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(der)
+		case "PRIVATE KEY":
+			return x509.ParsePKCS8PrivateKey(der)
+		case "ENCRYPTED PRIVATE KEY":
+			return parseEncryptedPKCS8(path, der, passphrase)
+		}
+	}
+}
+
+// Parse a DER private key, trying each of the formats in turn.
+func parsePrivateKeyDER(path string, data []byte, passphrase passphraseFunc) (interface{}, error) {
+	if err := checkTrailingData(data); err != nil {
+		return nil, fmt.Errorf("not a PEM or DER encoded PKCS#1, PKCS#8 or SEC1 private key")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(data); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(data); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(data); err == nil {
+		return key, nil
+	}
+	info := struct {
+		Algorithm     pkix.AlgorithmIdentifier
+		EncryptedData []byte
+	}{}
+	if _, err := asn1.Unmarshal(data, &info); err == nil {
+		return parseEncryptedPKCS8(path, data, passphrase)
+	}
+	return nil, fmt.Errorf("not a PEM or DER encoded PKCS#1, PKCS#8 or SEC1 private key")
+}
+
+// Make sure der is a single ASN.1 value. x509.ParsePKCS8PrivateKey quietly
+// ignores anything after the key, which is best not taken as a key file.
+func checkTrailingData(der []byte) error {
+	var value asn1.RawValue
+	rest, err := asn1.Unmarshal(der, &value)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("trailing data after the private key")
+	}
+	return nil
+}
+
+// Parse an EncryptedPrivateKeyInfo, asking for the passphrase.
+func parseEncryptedPKCS8(path string, der []byte, passphrase passphraseFunc) (interface{}, error) {
+	password, err := passphrase(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(password) == 0 {
+		// The pkcs8 package takes an empty password to mean the key isn't
+		// encrypted at all.
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	key, _, err := pkcs8.ParsePrivateKey(der, password)
+	if err != nil {
+		if strings.Contains(err.Error(), "incorrect password") {
+			return nil, fmt.Errorf("wrong passphrase")
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// vim: foldmethod=marker
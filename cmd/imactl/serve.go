@@ -0,0 +1,128 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"crypto"
+
+	"github.com/urfave/cli"
+
+	"pault.ag/go/ima/remote"
+)
+
+// Load the clients file, which has one client per line, of the form
+// "name token key[,key...]". Blank lines and lines starting with '#' are
+// ignored.
+func loadClients(path string) ([]remote.Client, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	clients := []remote.Client{}
+	scanner := bufio.NewScanner(fd)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("imactl: %s:%d: expected name, token and keys", path, line)
+		}
+		clients = append(clients, remote.Client{
+			Name:  fields[0],
+			Token: fields[1],
+			Keys:  strings.Split(fields[2], ","),
+		})
+	}
+	return clients, scanner.Err()
+}
+
+func Serve(c *cli.Context) error {
+	keys := map[string]crypto.Signer{}
+	for _, key := range c.StringSlice("key") {
+		parts := strings.SplitN(key, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("imactl: --key must be name=path, not %q", key)
+		}
+		signer, err := loadSigner(parts[1], passphraseSource(c))
+		if err != nil {
+			return err
+		}
+		defer closeSigner(signer)
+		keys[parts[0]] = signer
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("imactl: at least one --key is needed")
+	}
+
+	clients, err := loadClients(c.String("clients"))
+	if err != nil {
+		return err
+	}
+
+	server := &remote.Server{
+		Keys:    keys,
+		Clients: clients,
+		Logger:  log.New(os.Stderr, "", log.LstdFlags),
+	}
+	server.Logger.Printf("imactl: serving %d keys to %d clients on %s", len(keys), len(clients), c.String("listen"))
+
+	if c.String("tls-cert") != "" {
+		return http.ListenAndServeTLS(c.String("listen"), c.String("tls-cert"), c.String("tls-key"), server)
+	}
+	return http.ListenAndServe(c.String("listen"), server)
+}
+
+var ServeCommand = cli.Command{
+	Name:   "serve",
+	Action: Wrapper(Serve),
+	Usage:  "serve a remote signing API",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "listen",
+			Usage: "address to listen on",
+			Value: "127.0.0.1:8700",
+		},
+		cli.StringSliceFlag{
+			Name:  "key",
+			Usage: "name=path of a private key file or pkcs11: URI to serve (repeatable)",
+		},
+		cli.StringFlag{
+			Name:  "clients",
+			Usage: "file of clients, one \"name token key[,key...]\" per line",
+			Value: "/etc/imactl/clients",
+		},
+		cli.StringFlag{
+			Name:  "tls-cert",
+			Usage: "serve HTTPS with this certificate",
+		},
+		cli.StringFlag{
+			Name:  "tls-key",
+			Usage: "private key for --tls-cert",
+		},
+	},
+}
+
+// vim: foldmethod=marker
@@ -15,39 +15,220 @@
 package main
 
 import (
-	"crypto"
-	"crypto/rand"
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+
+	"encoding/base64"
+	"encoding/hex"
+
+	"crypto"
 
 	"github.com/urfave/cli"
 
+	"pault.ag/go/ima"
+	"pault.ag/go/ima/remote"
+	"pault.ag/go/ima/tlog"
 	"pault.ag/go/ima/xattr"
 )
 
+// Load a remote.Signer if --remote is set, or the local signer otherwise.
+func loadSignCommandSigner(c *cli.Context) (crypto.Signer, error) {
+	if c.String("remote") == "" {
+		return LoadSigner(c)
+	}
+	token := os.Getenv("IMACTL_REMOTE_TOKEN")
+	if path := c.String("remote-token-file"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	return remote.NewSigner(c.String("remote"), token, c.String("remote-key"))
+}
+
+// Parse the --hash flag into the options to sign with.
+func signatureOptions(c *cli.Context) (ima.SignatureOptions, error) {
+	hash, err := ima.HashFunctions.ByName(c.String("hash"))
+	if err != nil {
+		return ima.SignatureOptions{}, fmt.Errorf("imactl: unknown --hash %q", c.String("hash"))
+	}
+	return ima.SignatureOptions{Hash: *hash}, nil
+}
+
 func Sign(c *cli.Context) error {
-	signer, err := LoadSigner(c)
+	opts, err := signatureOptions(c)
+	if err != nil {
+		return err
+	}
+	if c.Bool("digest") {
+		return signDigests(c, opts)
+	}
+
+	stores, err := signatureStores(c)
 	if err != nil {
 		return err
 	}
+	signer, err := loadSignCommandSigner(c)
+	if err != nil {
+		return err
+	}
+	defer closeSigner(signer)
+
+	log, err := openLog(c)
+	if err != nil {
+		return err
+	}
+	if log != nil {
+		defer log.Close()
+	}
 
 	for _, path := range c.Args() {
-		fd, err := os.Open(path)
-		if err != nil {
+		if err := signFile(signer, opts, log, path, stores); err != nil {
 			return err
 		}
-		defer fd.Close()
-		if err := xattr.Sign(signer, rand.Reader, crypto.SHA256, fd); err != nil {
+	}
+	return nil
+}
+
+// Measure the file at path, sign it, and put the same signature in each of
+// the stores.
+func signFile(signer crypto.Signer, opts ima.SignatureOptions, log *tlog.Log, path string, stores []xattr.Store) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	hash, err := opts.Hash.New()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(hash, fd); err != nil {
+		return err
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	sig, err := logSign(log, signer, hash.Sum(nil), opts, path)
+	if err != nil {
+		return err
+	}
+
+	for _, store := range stores {
+		if err := store.Set(fd, sig); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// Sign hex digests from the arguments, or one per line on stdin if there
+// aren't any, and print the serialized signatures.
+func signDigests(c *cli.Context, opts ima.SignatureOptions) error {
+	encoding := c.String("encoding")
+	switch encoding {
+	case "", "hex", "base64":
+	default:
+		return fmt.Errorf("imactl: unknown --encoding %q", encoding)
+	}
+
+	digests := []string(c.Args())
+	if len(digests) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				digests = append(digests, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	if len(digests) == 0 {
+		return fmt.Errorf("imactl: no digests to sign")
+	}
+
+	signer, err := loadSignCommandSigner(c)
+	if err != nil {
+		return err
+	}
+	defer closeSigner(signer)
+	log, err := openLog(c)
+	if err != nil {
+		return err
+	}
+	if log != nil {
+		defer log.Close()
+	}
+
+	for _, text := range digests {
+		digest, err := hex.DecodeString(text)
+		if err != nil {
+			return fmt.Errorf("imactl: digest %q is not hex", text)
+		}
+		if len(digest) != opts.Hash.Size {
+			return fmt.Errorf("imactl: digest %q is %d bytes, but %s digests are %d bytes", text, len(digest), opts.Hash.Name, opts.Hash.Size)
+		}
+		sig, err := logSign(log, signer, digest, opts, "")
+		if err != nil {
+			return err
+		}
+		switch encoding {
+		case "hex":
+			fmt.Printf("%x\n", sig)
+		case "base64":
+			fmt.Println(base64.StdEncoding.EncodeToString(sig))
+		default:
+			fmt.Printf("digest: %s\nhex: %x\nbase64: %s\n", text, sig, base64.StdEncoding.EncodeToString(sig))
+		}
+	}
+	return nil
+}
+
+// Flags to sign with a key on an imactl serve server.
+var remoteFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "remote",
+		Usage: "sign with a key on this imactl serve URL, rather than --privkey",
+	},
+	cli.StringFlag{
+		Name:  "remote-key",
+		Usage: "name of the key to use on the --remote server",
+	},
+	cli.StringFlag{
+		Name:  "remote-token-file",
+		Usage: "file with the --remote bearer token (default: $IMACTL_REMOTE_TOKEN)",
+	},
+}
+
 var SignCommand = cli.Command{
-	Name:   "sign",
-	Action: Wrapper(Sign),
-	Usage:  "sign a file",
-	Flags:  []cli.Flag{},
+	Name:      "sign",
+	Action:    Wrapper(Sign),
+	Usage:     "sign files, or with --digest, hex digests of files measured elsewhere",
+	ArgsUsage: "FILE... | --digest [DIGEST...]",
+	Flags: append([]cli.Flag{
+		StoreFlag,
+		cli.StringFlag{
+			Name:  "hash",
+			Usage: "hash algorithm to measure files with, or that the --digest values were made with",
+			Value: "sha256",
+		},
+		cli.BoolFlag{
+			Name:  "digest",
+			Usage: "sign hex digests from the arguments, or stdin, and print the signatures",
+		},
+		cli.StringFlag{
+			Name:  "encoding",
+			Usage: "with --digest, print only the hex or base64 signature, one per line",
+		},
+		LogFlag,
+	}, remoteFlags...),
 }
 
 // vim: foldmethod=marker
@@ -15,27 +15,77 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"os"
 
 	"github.com/urfave/cli"
 
+	"pault.ag/go/ima"
+	"pault.ag/go/ima/httpkeys"
 	"pault.ag/go/ima/xattr"
 )
 
 func Verify(c *cli.Context) error {
-	pool, err := LoadPool(c)
+	stores, err := signatureStores(c)
 	if err != nil {
 		return err
 	}
 
+	opts := ima.VerifyOptions{
+		Warn: func(err error) {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+		},
+	}
+
+	if url := c.String("keyserver"); url != "" {
+		resolver := httpkeys.NewResolver(url)
+		if resolver.TrustAnchors, err = LoadTrustAnchors(c); err != nil {
+			return err
+		}
+		opts.Keys = resolver
+	} else {
+		pool, err := LoadPool(c)
+		if err != nil {
+			return err
+		}
+		opts.Keys = *pool
+	}
+	if paths := c.StringSlice("blocklist"); len(paths) != 0 {
+		opts.Blocklist = ima.NewBlocklist()
+		if err := opts.Blocklist.Load(paths...); err != nil {
+			return err
+		}
+	}
+
+	switch c.String("check-certificates") {
+	case "", "ignore":
+		opts.CertificatePolicy = ima.CertificatesIgnore
+	case "warn":
+		opts.CertificatePolicy = ima.CertificatesWarn
+	case "enforce":
+		opts.CertificatePolicy = ima.CertificatesEnforce
+	default:
+		return fmt.Errorf("imactl: unknown --check-certificates policy %q", c.String("check-certificates"))
+	}
+
 	for _, path := range c.Args() {
 		fd, err := os.Open(path)
 		if err != nil {
 			return err
 		}
 		defer fd.Close()
-		if err := xattr.Verify(fd, *pool); err != nil {
-			return err
+		for _, store := range stores {
+			if _, err := fd.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			result := xattr.VerifyStoreResult(store, fd, opts)
+			if result.Err != nil {
+				return fmt.Errorf("imactl: %s (%s): %s: %w", path, store, result.Status, result.Err)
+			}
+			if c.Bool("verbose") {
+				fmt.Printf("%s (%s): %s, key %x, %s %x\n", path, store, result.Status, result.KeyId, result.Hash.Name, result.Digest)
+			}
 		}
 	}
 	return nil
@@ -45,7 +95,26 @@ var VerifyCommand = cli.Command{
 	Name:   "verify",
 	Action: Wrapper(Verify),
 	Usage:  "verify a file",
-	Flags:  []cli.Flag{},
+	Flags: []cli.Flag{
+		StoreFlag,
+		cli.BoolFlag{
+			Name:  "verbose",
+			Usage: "print the key ID and digest of each valid signature",
+		},
+		cli.StringFlag{
+			Name:  "check-certificates",
+			Usage: "check signing certificate validity and key usage: ignore, warn or enforce",
+			Value: "ignore",
+		},
+		cli.StringFlag{
+			Name:  "keyserver",
+			Usage: "fetch public keys from this key server URL instead of --pubkey; with --ca, only keys in certificates issued by those CAs are accepted",
+		},
+		cli.StringSliceFlag{
+			Name:  "blocklist",
+			Usage: "file, directory or glob of CRLs and blocklists of revoked keys and digests (repeatable)",
+		},
+	},
 }
 
 // vim: foldmethod=marker
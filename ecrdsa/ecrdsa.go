@@ -0,0 +1,297 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ecrdsa implements EC-RDSA signatures, as defined in GOST R 34.10-2012
+// (RFC 7091), over the parameter sets the Linux kernel supports. Signatures
+// are encoded as s || r, each a big endian integer the size of the curve,
+// which is the format the kernel expects.
+//
+// All supported parameter sets have 'a = -3', so the generic
+// elliptic.CurveParams implementation is used for the arithmetic.
+package ecrdsa
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"crypto/rand"
+)
+
+var (
+	initOnce sync.Once
+	cp256a   *elliptic.CurveParams
+	cp256b   *elliptic.CurveParams
+	cp256c   *elliptic.CurveParams
+	tc512a   *elliptic.CurveParams
+	tc512b   *elliptic.CurveParams
+	one      = big.NewInt(1)
+)
+
+func newCurve(name string, bitSize int, p, n, b, gx, gy string) *elliptic.CurveParams {
+	curve := &elliptic.CurveParams{Name: name, BitSize: bitSize}
+	curve.P, _ = new(big.Int).SetString(p, 16)
+	curve.N, _ = new(big.Int).SetString(n, 16)
+	curve.B, _ = new(big.Int).SetString(b, 16)
+	curve.Gx, _ = new(big.Int).SetString(gx, 16)
+	curve.Gy, _ = new(big.Int).SetString(gy, 16)
+	return curve
+}
+
+func initCurves() {
+	cp256a = newCurve(
+		"id-GostR3410-2001-CryptoPro-A-ParamSet", 256,
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFD97",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF6C611070995AD10045841B09B761B893",
+		"A6",
+		"1",
+		"8D91E471E0989CDA27DF505A453F2B7635294F2DDF23E3B122ACC99C9E9F1E14",
+	)
+	cp256b = newCurve(
+		"id-GostR3410-2001-CryptoPro-B-ParamSet", 256,
+		"8000000000000000000000000000000000000000000000000000000000000C99",
+		"800000000000000000000000000000015F700CFFF1A624E5E497161BCC8A198F",
+		"3E1AF419A269A5F866A7D3C25C3DF80AE979259373FF2B182F49D4CE7E1BBC8B",
+		"1",
+		"3FA8124359F96680B83D1C3EB2C070E5C545C9858D03ECFB744BF8D717717EFC",
+	)
+	cp256c = newCurve(
+		"id-GostR3410-2001-CryptoPro-C-ParamSet", 256,
+		"9B9F605F5A858107AB1EC85E6B41C8AACF846E86789051D37998F7B9022D759B",
+		"9B9F605F5A858107AB1EC85E6B41C8AA582CA3511EDDFB74F02F3A6598980BB9",
+		"805A",
+		"0",
+		"41ECE55743711A8C3CBF3783CD08C0EE4D4DC440D4641A8F366E550DFDB3BB67",
+	)
+	tc512a = newCurve(
+		"id-tc26-gost-3410-12-512-paramSetA", 512,
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"+
+			"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFDC7",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"+
+			"27E69532F48D89116FF22B8D4E0560609B4B38ABFAD2B85DCACDB1411F10B275",
+		"E8C2505DEDFC86DDC1BD0B2B6667F1DA34B82574761CB0E879BD081CFD0B6265"+
+			"EE3CB090F30D27614CB4574010DA90DD862EF9D4EBEE4761503190785A71C760",
+		"3",
+		"7503CFE87A836AE3A61B8816E25450E6CE5E1C93ACF1ABC1778064FDCBEFA921"+
+			"DF1626BE4FD036E93D75E6A50E3A41E98028FE5FC235F5B889A589CB5215F2A4",
+	)
+	tc512b = newCurve(
+		"id-tc26-gost-3410-12-512-paramSetB", 512,
+		"8000000000000000000000000000000000000000000000000000000000000000"+
+			"000000000000000000000000000000000000000000000000000000000000006F",
+		"8000000000000000000000000000000000000000000000000000000000000001"+
+			"49A1EC142565A545ACFDB77BD9D40CFA8B996712101BEA0EC6346C54374F25BD",
+		"687D1B459DC841457E3E06CF6F5E2517B97C7D614AF138BCBF85DC806C4B289F"+
+			"3E965D2DB1416D217F8B276FAD1AB69C50F78BEE1FA3106EFB8CCBC7C5140116",
+		"2",
+		"1A8F7EDA389B094C2C071E3647A8940F3C123B697578C213BE6DD9E6C8EC7335"+
+			"DCB228FD1EDF4A39152CBCAAF8C0398828041055F94CEEEC7E21340780FE41BD",
+	)
+}
+
+// Return the CryptoPro A parameter set, which is the same curve as
+// tc26-gost-3410-12-256-paramSetB.
+func CryptoProA() elliptic.Curve {
+	initOnce.Do(initCurves)
+	return cp256a
+}
+
+// Return the CryptoPro B parameter set, which is the same curve as
+// tc26-gost-3410-12-256-paramSetC.
+func CryptoProB() elliptic.Curve {
+	initOnce.Do(initCurves)
+	return cp256b
+}
+
+// Return the CryptoPro C parameter set, which is the same curve as
+// tc26-gost-3410-12-256-paramSetD.
+func CryptoProC() elliptic.Curve {
+	initOnce.Do(initCurves)
+	return cp256c
+}
+
+// Return the tc26-gost-3410-12-512-paramSetA parameter set.
+func TC26512A() elliptic.Curve {
+	initOnce.Do(initCurves)
+	return tc512a
+}
+
+// Return the tc26-gost-3410-12-512-paramSetB parameter set.
+func TC26512B() elliptic.Curve {
+	initOnce.Do(initCurves)
+	return tc512b
+}
+
+// EC-RDSA Public Key.
+type PublicKey struct {
+	elliptic.Curve
+	X, Y *big.Int
+}
+
+// EC-RDSA Private Key. This implements crypto.Signer, and may be passed to
+// ima.Sign.
+type PrivateKey struct {
+	PublicKey
+	D *big.Int
+}
+
+// Generate a new EC-RDSA Private Key on the provided curve.
+func GenerateKey(curve elliptic.Curve, random io.Reader) (*PrivateKey, error) {
+	d, err := rand.Int(random, new(big.Int).Sub(curve.Params().N, one))
+	if err != nil {
+		return nil, err
+	}
+	d.Add(d, one)
+
+	priv := PrivateKey{D: d}
+	priv.Curve = curve
+	priv.X, priv.Y = curve.ScalarBaseMult(d.Bytes())
+	return &priv, nil
+}
+
+// Size of the curve, which is the size of the digest it must be used with,
+// and the size of each half of the signature.
+func (pub *PublicKey) size() int {
+	return (pub.Params().BitSize + 7) / 8
+}
+
+// Return the subjectPublicKey of the Public Key, as it's encoded in a
+// certificate. This is a DER OCTET STRING of the little endian X and Y
+// coordinates.
+func (pub *PublicKey) Bytes() []byte {
+	size := pub.size()
+	point := make([]byte, 2*size)
+	reverse(pub.X.FillBytes(point[:size]))
+	reverse(pub.Y.FillBytes(point[size:]))
+
+	if len(point) < 0x80 {
+		return append([]byte{0x04, byte(len(point))}, point...)
+	}
+	return append([]byte{0x04, 0x81, byte(len(point))}, point...)
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+// Compute e, the little endian digest reduced mod n, which is never zero.
+func (pub *PublicKey) hashToInt(digest []byte) (*big.Int, error) {
+	if len(digest) != pub.size() {
+		return nil, fmt.Errorf(
+			"ecrdsa: expected digest length of %d, got %d",
+			pub.size(),
+			len(digest),
+		)
+	}
+	e := new(big.Int).SetBytes(reverse(append([]byte{}, digest...)))
+	e.Mod(e, pub.Params().N)
+	if e.Sign() == 0 {
+		e.SetInt64(1)
+	}
+	return e, nil
+}
+
+// Report whether pub and x have the same value.
+func (pub *PublicKey) Equal(x crypto.PublicKey) bool {
+	other, ok := x.(*PublicKey)
+	if !ok {
+		return false
+	}
+	return pub.Curve == other.Curve &&
+		pub.X.Cmp(other.X) == 0 &&
+		pub.Y.Cmp(other.Y) == 0
+}
+
+// Return the Public Key, in order to implement crypto.Signer.
+func (priv *PrivateKey) Public() crypto.PublicKey {
+	return &priv.PublicKey
+}
+
+// Sign the digest with the Private Key. The opts are ignored, but the
+// digest must be a Streebog digest of the same size as the curve, which is to
+// say Streebog-256 for 256 bit curves, and Streebog-512 for 512 bit curves.
+func (priv *PrivateKey) Sign(random io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	n := priv.Params().N
+	e, err := priv.hashToInt(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		k, err := rand.Int(random, new(big.Int).Sub(n, one))
+		if err != nil {
+			return nil, err
+		}
+		k.Add(k, one)
+
+		r, _ := priv.ScalarBaseMult(k.Bytes())
+		r.Mod(r, n)
+		if r.Sign() == 0 {
+			continue
+		}
+
+		s := new(big.Int).Mul(r, priv.D)
+		s.Add(s, k.Mul(k, e))
+		s.Mod(s, n)
+		if s.Sign() == 0 {
+			continue
+		}
+
+		size := priv.size()
+		sig := make([]byte, 2*size)
+		s.FillBytes(sig[:size])
+		r.FillBytes(sig[size:])
+		return sig, nil
+	}
+}
+
+// Verify the s || r signature over the digest with the Public Key.
+func Verify(pub *PublicKey, digest, sig []byte) bool {
+	n := pub.Params().N
+	size := pub.size()
+	if len(sig) != 2*size {
+		return false
+	}
+	s := new(big.Int).SetBytes(sig[:size])
+	r := new(big.Int).SetBytes(sig[size:])
+	if r.Sign() <= 0 || s.Sign() <= 0 || r.Cmp(n) >= 0 || s.Cmp(n) >= 0 {
+		return false
+	}
+
+	e, err := pub.hashToInt(digest)
+	if err != nil {
+		return false
+	}
+	v := new(big.Int).ModInverse(e, n)
+	if v == nil {
+		return false
+	}
+	z1 := new(big.Int).Mul(s, v)
+	z1.Mod(z1, n)
+	z2 := new(big.Int).Mul(r, v)
+	z2.Neg(z2)
+	z2.Mod(z2, n)
+
+	x1, y1 := pub.ScalarBaseMult(z1.Bytes())
+	x2, y2 := pub.ScalarMult(pub.X, pub.Y, z2.Bytes())
+	x, _ := pub.Add(x1, y1, x2, y2)
+	x.Mod(x, n)
+	return x.Cmp(r) == 0
+}
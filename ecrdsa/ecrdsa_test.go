@@ -0,0 +1,222 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ecrdsa_test
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"encoding/hex"
+
+	"crypto/elliptic"
+	"crypto/rand"
+
+	"pault.ag/go/ima/ecrdsa"
+	"pault.ag/go/ima/streebog"
+)
+
+var curves = []elliptic.Curve{
+	ecrdsa.CryptoProA(),
+	ecrdsa.CryptoProB(),
+	ecrdsa.CryptoProC(),
+	ecrdsa.TC26512A(),
+	ecrdsa.TC26512B(),
+}
+
+func TestCurves(t *testing.T) {
+	for _, curve := range curves {
+		params := curve.Params()
+		assert(t, curve.IsOnCurve(params.Gx, params.Gy))
+
+		// n * G is the point at infinity, which is (0, 0) in crypto/elliptic.
+		x, y := curve.ScalarBaseMult(params.N.Bytes())
+		assert(t, x.Sign() == 0 && y.Sign() == 0)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	for _, curve := range curves {
+		key, err := ecrdsa.GenerateKey(curve, rand.Reader)
+		isok(t, err)
+
+		digest := streebog.New512()
+		if curve.Params().BitSize == 256 {
+			digest = streebog.New256()
+		}
+		digest.Write([]byte("Totally real ELF no tricks"))
+		sum := digest.Sum(nil)
+
+		sig, err := key.Sign(rand.Reader, sum, nil)
+		isok(t, err)
+		assert(t, len(sig) == 2*len(sum))
+		assert(t, ecrdsa.Verify(&key.PublicKey, sum, sig))
+
+		sum[0] ^= 0xFF
+		assert(t, !ecrdsa.Verify(&key.PublicKey, sum, sig))
+		assert(t, !ecrdsa.Verify(&key.PublicKey, sum[1:], sig))
+	}
+}
+
+// Short Weierstrass curve with any a, since elliptic.CurveParams only
+// handles a = -3, and the GOST R 34.10-2012 example curve has a = 7. The
+// point at infinity is (0, 0), as in crypto/elliptic.
+type testCurve struct {
+	params *elliptic.CurveParams
+	a      *big.Int
+}
+
+func (c testCurve) Params() *elliptic.CurveParams {
+	return c.params
+}
+
+func (c testCurve) IsOnCurve(x, y *big.Int) bool {
+	p := c.params.P
+	lhs := new(big.Int).Mul(y, y)
+	rhs := new(big.Int).Mul(x, x)
+	rhs.Add(rhs, c.a)
+	rhs.Mul(rhs, x)
+	rhs.Add(rhs, c.params.B)
+	return lhs.Sub(lhs, rhs).Mod(lhs, p).Sign() == 0
+}
+
+func (c testCurve) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	p := c.params.P
+	if x1.Sign() == 0 && y1.Sign() == 0 {
+		return new(big.Int).Set(x2), new(big.Int).Set(y2)
+	}
+	if x2.Sign() == 0 && y2.Sign() == 0 {
+		return new(big.Int).Set(x1), new(big.Int).Set(y1)
+	}
+
+	l := new(big.Int)
+	if x1.Cmp(x2) == 0 {
+		if l.Add(y1, y2).Mod(l, p).Sign() == 0 {
+			return new(big.Int), new(big.Int)
+		}
+		l.Mul(x1, x1).Mul(l, big.NewInt(3)).Add(l, c.a)
+		l.Mul(l, new(big.Int).ModInverse(new(big.Int).Lsh(y1, 1), p))
+	} else {
+		dx := new(big.Int).Sub(x2, x1)
+		l.Sub(y2, y1)
+		l.Mul(l, dx.ModInverse(dx.Mod(dx, p), p))
+	}
+	l.Mod(l, p)
+
+	x3 := new(big.Int).Mul(l, l)
+	x3.Sub(x3, x1).Sub(x3, x2).Mod(x3, p)
+	y3 := new(big.Int).Sub(x1, x3)
+	y3.Mul(y3, l).Sub(y3, y1).Mod(y3, p)
+	return x3, y3
+}
+
+func (c testCurve) Double(x, y *big.Int) (*big.Int, *big.Int) {
+	return c.Add(x, y, x, y)
+}
+
+func (c testCurve) ScalarMult(x, y *big.Int, k []byte) (*big.Int, *big.Int) {
+	rx, ry := new(big.Int), new(big.Int)
+	for _, b := range k {
+		for bit := 7; bit >= 0; bit-- {
+			rx, ry = c.Double(rx, ry)
+			if b>>uint(bit)&1 == 1 {
+				rx, ry = c.Add(rx, ry, x, y)
+			}
+		}
+	}
+	return rx, ry
+}
+
+func (c testCurve) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return c.ScalarMult(c.params.Gx, c.params.Gy, k)
+}
+
+func hexInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic(s)
+	}
+	return n
+}
+
+// Known answer from the GOST R 34.10-2012 Appendix A.1 example, also in
+// RFC 7091 section 7.1, laid out the way the kernel takes it: a little
+// endian digest, and a big endian s || r signature.
+func TestKnownAnswer(t *testing.T) {
+	curve := testCurve{
+		params: &elliptic.CurveParams{
+			Name:    "GOST R 34.10-2012 test curve",
+			BitSize: 256,
+			P:       hexInt("8000000000000000000000000000000000000000000000000000000000000431"),
+			N:       hexInt("8000000000000000000000000000000150fe8a1892976154c59cfc193accf5b3"),
+			B:       hexInt("5fbff498aa938ce739b8e022fbafef40563f6e6a3472fc2a514c0ce9dae23b7e"),
+			Gx:      big.NewInt(2),
+			Gy:      hexInt("08e2a8a0e65147d4bd6316030e16d19c85c97f0a9ca267122b96abbcea7e8fc8"),
+		},
+		a: big.NewInt(7),
+	}
+	d := hexInt("7a929ade789bb9be10ed359dd39a72c11b60961f49397eee1d19ce9891ec3b28")
+	k := hexInt("77105c9b20bcd3122823c8cf6fcc7b956de33814e95b7fe64fed924594dceab3")
+	e := hexInt("2dfbc1b372d89a1188c09c52e0eec61fce52032ab1022e8e67ece6672b043ee5")
+	pub := ecrdsa.PublicKey{
+		Curve: curve,
+		X:     hexInt("7f2b49e270db6d90d8595bec458b50c58585ba1d4e9b788f6689dbd8e56fd80b"),
+		Y:     hexInt("26f1b489d6701dd185c8413a977b3cbbaf64d1c593d26627dffb101a87ff77da"),
+	}
+	sig, err := hex.DecodeString(
+		"01456c64ba4642a1653c235a98a60249bcd6d3f746b631df928014f6c5bf9c40" +
+			"41aa28d2f1ab148280cd9ed56feda41974053554a42767b83ad043fd39dc0493")
+	isok(t, err)
+
+	digest := e.FillBytes(make([]byte, 32))
+	for i, j := 0, len(digest)-1; i < j; i, j = i+1, j-1 {
+		digest[i], digest[j] = digest[j], digest[i]
+	}
+
+	assert(t, curve.IsOnCurve(pub.X, pub.Y))
+	x, y := curve.ScalarBaseMult(d.Bytes())
+	assert(t, x.Cmp(pub.X) == 0 && y.Cmp(pub.Y) == 0)
+
+	assert(t, ecrdsa.Verify(&pub, digest, sig))
+	// Not r || s, and not a big endian digest.
+	assert(t, !ecrdsa.Verify(&pub, digest, append(append([]byte{}, sig[32:]...), sig[:32]...)))
+	assert(t, !ecrdsa.Verify(&pub, e.FillBytes(make([]byte, 32)), sig))
+
+	// Sign takes k - 1 from the random source, so this is the example's k.
+	priv := ecrdsa.PrivateKey{PublicKey: pub, D: d}
+	random := bytes.NewReader(new(big.Int).Sub(k, big.NewInt(1)).FillBytes(make([]byte, 32)))
+	signed, err := priv.Sign(random, digest, nil)
+	isok(t, err)
+	assert(t, bytes.Equal(signed, sig))
+}
+
+func TestPKIX(t *testing.T) {
+	for _, curve := range curves {
+		key, err := ecrdsa.GenerateKey(curve, rand.Reader)
+		isok(t, err)
+
+		der, err := ecrdsa.MarshalPKIXPublicKey(&key.PublicKey)
+		isok(t, err)
+		pub, err := ecrdsa.ParsePKIXPublicKey(der)
+		isok(t, err)
+		assert(t, pub.Equal(&key.PublicKey))
+
+		_, err = ecrdsa.ParsePKIXPublicKey(append(der, 0))
+		notok(t, err)
+		der[len(der)-1] ^= 0xff
+		_, err = ecrdsa.ParsePKIXPublicKey(der)
+		notok(t, err)
+	}
+}
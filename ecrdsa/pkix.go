@@ -0,0 +1,147 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ecrdsa
+
+import (
+	"fmt"
+	"math/big"
+
+	"encoding/asn1"
+
+	"crypto/elliptic"
+	"crypto/x509/pkix"
+)
+
+var (
+	oidGost2012PKey256 = asn1.ObjectIdentifier{1, 2, 643, 7, 1, 1, 1, 1}
+	oidGost2012PKey512 = asn1.ObjectIdentifier{1, 2, 643, 7, 1, 1, 1, 2}
+	oidStreebog256     = asn1.ObjectIdentifier{1, 2, 643, 7, 1, 1, 2, 2}
+)
+
+// Parameter set OIDs, by curve. The first OID of each is the one used when
+// encoding; the rest are aliases for the same curve.
+var paramSets = []struct {
+	curve func() elliptic.Curve
+	oids  []asn1.ObjectIdentifier
+}{
+	{CryptoProA, []asn1.ObjectIdentifier{
+		{1, 2, 643, 2, 2, 35, 1}, {1, 2, 643, 2, 2, 36, 0}, {1, 2, 643, 7, 1, 2, 1, 1, 2},
+	}},
+	{CryptoProB, []asn1.ObjectIdentifier{
+		{1, 2, 643, 2, 2, 35, 2}, {1, 2, 643, 7, 1, 2, 1, 1, 3},
+	}},
+	{CryptoProC, []asn1.ObjectIdentifier{
+		{1, 2, 643, 2, 2, 35, 3}, {1, 2, 643, 2, 2, 36, 1}, {1, 2, 643, 7, 1, 2, 1, 1, 4},
+	}},
+	{TC26512A, []asn1.ObjectIdentifier{{1, 2, 643, 7, 1, 2, 1, 2, 1}}},
+	{TC26512B, []asn1.ObjectIdentifier{{1, 2, 643, 7, 1, 2, 1, 2, 2}}},
+}
+
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+type publicKeyParameters struct {
+	PublicKeyParamSet asn1.ObjectIdentifier
+	DigestParamSet    asn1.ObjectIdentifier `asn1:"optional"`
+}
+
+// Encode the Public Key as a PKIX SubjectPublicKeyInfo, as described in
+// RFC 9215: a GOST R 34.10-2012 key with its parameter set, and the Bytes
+// of the key as the subjectPublicKey.
+func MarshalPKIXPublicKey(pub *PublicKey) ([]byte, error) {
+	params := publicKeyParameters{}
+	for _, set := range paramSets {
+		if set.curve() == pub.Curve {
+			params.PublicKeyParamSet = set.oids[0]
+		}
+	}
+	if params.PublicKeyParamSet == nil {
+		return nil, fmt.Errorf("ecrdsa: unsupported curve")
+	}
+
+	algorithm := oidGost2012PKey512
+	if pub.Params().BitSize == 256 {
+		algorithm = oidGost2012PKey256
+		params.DigestParamSet = oidStreebog256
+	}
+	paramBytes, err := asn1.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	point := pub.Bytes()
+	return asn1.Marshal(subjectPublicKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  algorithm,
+			Parameters: asn1.RawValue{FullBytes: paramBytes},
+		},
+		PublicKey: asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+	})
+}
+
+// Parse a PKIX SubjectPublicKeyInfo holding a GOST R 34.10-2012 Public Key
+// on one of the supported parameter sets.
+func ParsePKIXPublicKey(der []byte) (*PublicKey, error) {
+	spki := subjectPublicKeyInfo{}
+	if rest, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("ecrdsa: trailing data after public key")
+	}
+	bitSize := 0
+	switch {
+	case spki.Algorithm.Algorithm.Equal(oidGost2012PKey256):
+		bitSize = 256
+	case spki.Algorithm.Algorithm.Equal(oidGost2012PKey512):
+		bitSize = 512
+	default:
+		return nil, fmt.Errorf("ecrdsa: not a GOST R 34.10-2012 public key")
+	}
+
+	params := publicKeyParameters{}
+	if _, err := asn1.Unmarshal(spki.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	var curve elliptic.Curve
+	for _, set := range paramSets {
+		for _, oid := range set.oids {
+			if oid.Equal(params.PublicKeyParamSet) {
+				curve = set.curve()
+			}
+		}
+	}
+	if curve == nil || curve.Params().BitSize != bitSize {
+		return nil, fmt.Errorf("ecrdsa: unsupported parameter set %s", params.PublicKeyParamSet)
+	}
+
+	size := bitSize / 8
+	point := []byte{}
+	if _, err := asn1.Unmarshal(spki.PublicKey.RightAlign(), &point); err != nil {
+		return nil, err
+	}
+	if len(point) != 2*size {
+		return nil, fmt.Errorf("ecrdsa: bad public key length")
+	}
+	pub := &PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(reverse(point[:size])),
+		Y:     new(big.Int).SetBytes(reverse(point[size:])),
+	}
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, fmt.Errorf("ecrdsa: public key is not on the curve")
+	}
+	return pub, nil
+}
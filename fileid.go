@@ -0,0 +1,58 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ima
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Version 3 signatures are not made over the file digest directly, but
+// rather over the hash of an ima_file_id structure, which is laid out as:
+//
+//	struct ima_file_id {
+//		__u8 hash_type;
+//		__u8 hash_algorithm;
+//		__u8 hash[HASH_MAX_DIGESTSIZE];
+//	};
+//
+// Only the bytes of `hash` that are used by the digest are included when
+// hashing the structure, and the structure is hashed with the same algorithm
+// as the file digest itself.
+//
+// hashType is the xattr type the signature is stored as, which is to say
+// XattrDigSig for a regular IMA signature, or XattrVerityDigSig for a
+// signature over an fs-verity file digest.
+func FileIdDigest(hashType uint8, algorithm Hash, digest []byte) ([]byte, error) {
+	hash, err := algorithm.New()
+	if err != nil {
+		return nil, err
+	}
+	if len(digest) != hash.Size() {
+		return nil, fmt.Errorf(
+			"ima: expected digest length of %d, got %d",
+			hash.Size(),
+			len(digest),
+		)
+	}
+
+	fileId := bytes.Buffer{}
+	fileId.WriteByte(hashType)
+	fileId.WriteByte(algorithm.Id)
+	fileId.Write(digest)
+
+	hash.Write(fileId.Bytes())
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ima_test
+
+import (
+	"bytes"
+	"testing"
+
+	"encoding/hex"
+
+	"pault.ag/go/ima"
+)
+
+func mustHex(t *testing.T, data string) []byte {
+	ret, err := hex.DecodeString(data)
+	isok(t, err)
+	return ret
+}
+
+func TestFileIdDigest(t *testing.T) {
+	// ima_file_id hashes for the digests of an empty file. These are the
+	// hash of the hash_type and hash_algorithm bytes followed by the
+	// digest, which is what the kernel and evmctl sign for version 3.
+	for _, el := range []struct {
+		hashType uint8
+		hash     ima.Hash
+		digest   string
+		fileId   string
+	}{
+		{
+			hashType: ima.XattrDigSig,
+			hash:     ima.SHA256,
+			digest:   "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			fileId:   "d36888a7a1e864be2f7393e0f118ab1a04d6897de31180074c128e5302f1b68d",
+		},
+		{
+			hashType: ima.XattrVerityDigSig,
+			hash:     ima.SHA256,
+			digest:   "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			fileId:   "828d9a54f41daab48998a6ce304684c73dc30ee2a9e09da7e9da405ffcb3126c",
+		},
+		{
+			hashType: ima.XattrDigSig,
+			hash:     ima.SHA512,
+			digest: "cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce" +
+				"47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e",
+			fileId: "89751d4c0dd1d43398db8f835c7d4966d9d5170a1579027669c2878d79c53055" +
+				"3c0c79183e50f512d3977e0f95d7ca5d7402bc0492200cfa522b2febb36f7a6e",
+		},
+	} {
+		computed, err := ima.FileIdDigest(el.hashType, el.hash, mustHex(t, el.digest))
+		isok(t, err)
+		assert(t, bytes.Compare(computed, mustHex(t, el.fileId)) == 0)
+	}
+
+	digest := mustHex(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	_, err := ima.FileIdDigest(ima.XattrDigSig, ima.SHA256, digest[:20])
+	notok(t, err)
+}
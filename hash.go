@@ -17,6 +17,10 @@ package ima
 import (
 	"crypto"
 	"fmt"
+	"hash"
+
+	"pault.ag/go/ima/sm3"
+	"pault.ag/go/ima/streebog"
 )
 
 // Encapsulation of an IMA EVM Hash function. These should likely not be used
@@ -30,6 +34,77 @@ import (
 type Hash struct {
 	Id   uint8
 	Hash crypto.Hash
+
+	// Name of the Hash function, as used by the kernel and evmctl.
+	Name string
+
+	// Size of the digest, in bytes. This is known even if there's no
+	// implementation of the Hash function linked into the binary.
+	Size int
+}
+
+var (
+	// This is returned when an IMA EVM Hash ID isn't in the kernel's
+	// hash_info table, or registered with RegisterHash.
+	UnknownHash error = fmt.Errorf("ima: unknown IMA EVM hash id")
+
+	// This is returned when an IMA EVM Hash ID is known, but there's no
+	// implementation of it linked into the binary. For Hash functions with a
+	// native crypto.Hash, this means the package implementing it hasn't been
+	// imported. For the rest, an implementation must be registered with
+	// RegisterHash.
+	HashUnavailable error = fmt.Errorf("ima: IMA EVM hash has no implementation linked")
+)
+
+// Constructors for IMA EVM Hash functions, keyed by IMA EVM Hash ID. Hash
+// functions with a native Go crypto.Hash don't need to be in here, but if
+// they are, this takes priority.
+var hashConstructors = map[uint8]func() hash.Hash{
+	SM3.Id:         sm3.New,
+	Streebog256.Id: streebog.New256,
+	Streebog512.Id: streebog.New512,
+}
+
+// Register a function that returns a new instance of the Hash function with
+// the ID of the provided Hash. This allows Hash functions without an
+// implementation in the standard library, such as Whirlpool, to be used for
+// IMA EVM Signatures.
+//
+// If the Hash ID isn't already in HashFunctions, it will be added, so that
+// IMA EVM Hash IDs newer than this library can be used as well.
+//
+// Much like crypto.RegisterHash, this is intended to be called from an init
+// function, and it's not safe to call concurrently with anything else.
+func RegisterHash(h Hash, f func() hash.Hash) {
+	if _, err := HashFunctions.Lookup(h.Id); err == UnknownHash {
+		HashFunctions = append(HashFunctions, h)
+	}
+	hashConstructors[h.Id] = f
+}
+
+// Check to see if an implementation of the Hash function is linked into the
+// binary.
+func (h Hash) Available() bool {
+	if _, ok := hashConstructors[h.Id]; ok {
+		return true
+	}
+	return h.Hash != 0 && h.Hash.Available()
+}
+
+// Create a new hash.Hash computing this Hash function. This works for IMA EVM
+// Hash functions which don't have a native Go crypto.Hash, such as SM3, so
+// it should be preferred over Hash.Hash.New().
+//
+// If there's no implementation of the Hash function linked into the binary,
+// this will return HashUnavailable.
+func (h Hash) New() (hash.Hash, error) {
+	if constructor, ok := hashConstructors[h.Id]; ok {
+		return constructor(), nil
+	}
+	if h.Hash != 0 && h.Hash.Available() {
+		return h.Hash.New(), nil
+	}
+	return nil, HashUnavailable
 }
 
 // List of IMA EVM Hash functions.
@@ -39,6 +114,9 @@ type Hashes []Hash
 // functions in the Hashes, and find an IMA EVM Hash object that corresponds
 // to the native Go Hash function.
 func (h Hashes) ToHash(hash crypto.Hash) (*Hash, error) {
+	if hash == 0 {
+		return nil, fmt.Errorf("ima: no matching crypto.Hash found")
+	}
 	for _, imaHash := range h {
 		if imaHash.Hash == hash {
 			return &imaHash, nil
@@ -50,31 +128,90 @@ func (h Hashes) ToHash(hash crypto.Hash) (*Hash, error) {
 // Convert a ima.Hash to a crypto.Hash. This will enumerate the Hsah
 // functions in the Hashes, and find a crypto.hash that corresponds
 // to the IMA EVM hash ID.
+//
+// Some IMA EVM Hash functions, such as SM3, have no native Go crypto.Hash,
+// and will return HashUnavailable. Use Lookup to handle those. Hash IDs that
+// aren't known at all will return UnknownHash.
 func (h Hashes) ToCrypto(hash uint8) (*crypto.Hash, error) {
+	imaHash, err := h.Lookup(hash)
+	if err != nil {
+		return nil, err
+	}
+	if imaHash.Hash == 0 {
+		return nil, HashUnavailable
+	}
+	return &imaHash.Hash, nil
+}
+
+// Find the ima.Hash with the provided IMA EVM hash ID. This is handy when
+// the full Hash is needed, rather than just the crypto.Hash, such as when
+// serializing the hash ID back out.
+//
+// Every Hash ID in the kernel's hash_info table will be found, even if there's
+// no implementation of it linked in; use Hash.Available to check. Hash IDs that
+// aren't known at all will return UnknownHash.
+func (h Hashes) Lookup(id uint8) (*Hash, error) {
+	for _, imaHash := range h {
+		if imaHash.Id == id {
+			return &imaHash, nil
+		}
+	}
+	return nil, UnknownHash
+}
+
+// Find the ima.Hash with the provided name, as used by the kernel and
+// evmctl, such as "sha256". Names that aren't known will return UnknownHash.
+func (h Hashes) ByName(name string) (*Hash, error) {
 	for _, imaHash := range h {
-		if imaHash.Id == hash {
-			return &imaHash.Hash, nil
+		if imaHash.Name == name {
+			return &imaHash, nil
 		}
 	}
-	return nil, fmt.Errorf("ima: no matching IMA EVM hash found")
+	return nil, UnknownHash
 }
 
+// IMA EVM Hash functions, as defined by the kernel's hash_info table.
 var (
-	MD4 Hash = Hash{Id: 0, Hash: crypto.MD4}
-	MD5 Hash = Hash{Id: 1, Hash: crypto.MD5}
+	MD4 Hash = Hash{Id: 0, Hash: crypto.MD4, Name: "md4", Size: 16}
+	MD5 Hash = Hash{Id: 1, Hash: crypto.MD5, Name: "md5", Size: 16}
+
+	RIPEMD160 Hash = Hash{Id: 3, Hash: crypto.RIPEMD160, Name: "rmd160", Size: 20}
+	RIPEMD128 Hash = Hash{Id: 8, Name: "rmd128", Size: 16}
+	RIPEMD256 Hash = Hash{Id: 9, Name: "rmd256", Size: 32}
+	RIPEMD320 Hash = Hash{Id: 10, Name: "rmd320", Size: 40}
+
+	SHA1   Hash = Hash{Id: 2, Hash: crypto.SHA1, Name: "sha1", Size: 20}
+	SHA224 Hash = Hash{Id: 7, Hash: crypto.SHA224, Name: "sha224", Size: 28}
+	SHA256 Hash = Hash{Id: 4, Hash: crypto.SHA256, Name: "sha256", Size: 32}
+	SHA384 Hash = Hash{Id: 5, Hash: crypto.SHA384, Name: "sha384", Size: 48}
+	SHA512 Hash = Hash{Id: 6, Hash: crypto.SHA512, Name: "sha512", Size: 64}
+
+	Whirlpool256 Hash = Hash{Id: 11, Name: "wp256", Size: 32}
+	Whirlpool384 Hash = Hash{Id: 12, Name: "wp384", Size: 48}
+	Whirlpool512 Hash = Hash{Id: 13, Name: "wp512", Size: 64}
+
+	Tiger128 Hash = Hash{Id: 14, Name: "tgr128", Size: 16}
+	Tiger160 Hash = Hash{Id: 15, Name: "tgr160", Size: 20}
+	Tiger192 Hash = Hash{Id: 16, Name: "tgr192", Size: 24}
 
-	RIPEMD160 Hash = Hash{Id: 3, Hash: crypto.RIPEMD160}
+	// SM3 and Streebog have no native Go crypto.Hash, see Hash.New().
+	SM3         Hash = Hash{Id: 17, Name: "sm3", Size: 32}
+	Streebog256 Hash = Hash{Id: 18, Name: "streebog256", Size: 32}
+	Streebog512 Hash = Hash{Id: 19, Name: "streebog512", Size: 64}
 
-	SHA1   Hash = Hash{Id: 2, Hash: crypto.SHA1}
-	SHA224 Hash = Hash{Id: 7, Hash: crypto.SHA224}
-	SHA256 Hash = Hash{Id: 4, Hash: crypto.SHA256}
-	SHA384 Hash = Hash{Id: 5, Hash: crypto.SHA384}
-	SHA512 Hash = Hash{Id: 6, Hash: crypto.SHA512}
+	SHA3_256 Hash = Hash{Id: 20, Hash: crypto.SHA3_256, Name: "sha3-256", Size: 32}
+	SHA3_384 Hash = Hash{Id: 21, Hash: crypto.SHA3_384, Name: "sha3-384", Size: 48}
+	SHA3_512 Hash = Hash{Id: 22, Hash: crypto.SHA3_512, Name: "sha3-512", Size: 64}
 
 	// List of all Hash functions.
 	HashFunctions = Hashes{
 		MD4, MD5,
-		RIPEMD160,
+		RIPEMD160, RIPEMD128, RIPEMD256, RIPEMD320,
 		SHA1, SHA224, SHA256, SHA384, SHA512,
+		Whirlpool256, Whirlpool384, Whirlpool512,
+		Tiger128, Tiger160, Tiger192,
+		SM3,
+		Streebog256, Streebog512,
+		SHA3_256, SHA3_384, SHA3_512,
 	}
 )
@@ -15,9 +15,13 @@
 package ima_test
 
 import (
-	"crypto"
+	"hash"
 	"testing"
 
+	"crypto"
+	"crypto/sha256"
+	_ "crypto/sha3"
+
 	"pault.ag/go/ima"
 )
 
@@ -44,3 +48,120 @@ func TestIMALookup(t *testing.T) {
 	assert(t, hash != nil)
 	assert(t, *hash == ima.SHA512)
 }
+
+func TestIdLookup(t *testing.T) {
+	hash, err := ima.HashFunctions.Lookup(4)
+	isok(t, err)
+	assert(t, hash != nil)
+	assert(t, *hash == ima.SHA256)
+
+	_, err = ima.HashFunctions.Lookup(0xFF)
+	notok(t, err)
+}
+
+func TestNameLookup(t *testing.T) {
+	hash, err := ima.HashFunctions.ByName("sha3-256")
+	isok(t, err)
+	assert(t, *hash == ima.SHA3_256)
+
+	_, err = ima.HashFunctions.ByName("sha257")
+	assert(t, err == ima.UnknownHash)
+}
+
+func TestSM3(t *testing.T) {
+	_, err := ima.HashFunctions.ToCrypto(ima.SM3.Id)
+	notok(t, err)
+
+	_, err = ima.HashFunctions.ToHash(crypto.Hash(0))
+	notok(t, err)
+
+	hash, err := ima.SM3.New()
+	isok(t, err)
+	assert(t, hash.Size() == 32)
+}
+
+func TestStreebog(t *testing.T) {
+	hash, err := ima.HashFunctions.Lookup(18)
+	isok(t, err)
+	assert(t, *hash == ima.Streebog256)
+
+	h, err := hash.New()
+	isok(t, err)
+	assert(t, h.Size() == 32)
+
+	h, err = ima.Streebog512.New()
+	isok(t, err)
+	assert(t, h.Size() == 64)
+}
+
+func TestKernelTable(t *testing.T) {
+	for id := 0; id <= 22; id++ {
+		hash, err := ima.HashFunctions.Lookup(uint8(id))
+		isok(t, err)
+		assert(t, hash.Name != "")
+		assert(t, hash.Size != 0)
+	}
+
+	_, err := ima.HashFunctions.Lookup(23)
+	assert(t, err == ima.UnknownHash)
+	_, err = ima.HashFunctions.ToCrypto(23)
+	assert(t, err == ima.UnknownHash)
+}
+
+func TestSHA3(t *testing.T) {
+	hash, err := ima.HashFunctions.ToCrypto(ima.SHA3_256.Id)
+	isok(t, err)
+	assert(t, *hash == crypto.SHA3_256)
+
+	h, err := ima.SHA3_512.New()
+	isok(t, err)
+	assert(t, h.Size() == ima.SHA3_512.Size)
+}
+
+func TestHashUnavailable(t *testing.T) {
+	hash, err := ima.HashFunctions.Lookup(ima.Whirlpool512.Id)
+	isok(t, err)
+	assert(t, !hash.Available())
+
+	_, err = hash.New()
+	assert(t, err == ima.HashUnavailable)
+	_, err = ima.HashFunctions.ToCrypto(ima.Whirlpool512.Id)
+	assert(t, err == ima.HashUnavailable)
+}
+
+func TestRegisterHash(t *testing.T) {
+	// Not really Tiger, but it's the right size.
+	ima.RegisterHash(ima.Tiger192, func() hash.Hash {
+		return truncated{Hash: sha256.New(), size: 24}
+	})
+	assert(t, ima.Tiger192.Available())
+	h, err := ima.Tiger192.New()
+	isok(t, err)
+	assert(t, h.Size() == 24)
+
+	// An ID from the future.
+	future := ima.Hash{Id: 0xF0, Name: "future256", Size: 32}
+	_, err = ima.HashFunctions.Lookup(future.Id)
+	assert(t, err == ima.UnknownHash)
+
+	ima.RegisterHash(future, sha256.New)
+	found, err := ima.HashFunctions.Lookup(future.Id)
+	isok(t, err)
+	assert(t, *found == future)
+	h, err = found.New()
+	isok(t, err)
+	assert(t, h.Size() == 32)
+}
+
+type truncated struct {
+	hash.Hash
+	size int
+}
+
+func (t truncated) Size() int {
+	return t.size
+}
+
+func (t truncated) Sum(b []byte) []byte {
+	return append(b, t.Hash.Sum(nil)[:t.size]...)
+}
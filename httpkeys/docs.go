@@ -0,0 +1,9 @@
+// A simple HTTP key server protocol, with an ima.KeyResolver client that
+// caches the keys it fetches, and an http.Handler to serve keys from any
+// ima.KeyResolver, such as an ima.KeyPool.
+//
+// Keys are fetched with a GET request for the lower case hex encoded IMA
+// KeyId, relative to the base URL, such as https://keys.example.com/1a2b3c4d.
+// The response is PEM encoded Certificates and public keys, or a 404 if there
+// are no keys with that KeyId.
+package httpkeys
@@ -0,0 +1,204 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpkeys
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"encoding/hex"
+	"encoding/pem"
+
+	"crypto/x509"
+
+	"pault.ag/go/ima"
+)
+
+// Responses larger than this are refused.
+const maxResponseSize = 1 << 20
+
+// How long Resolve results are cached by default.
+const DefaultTTL = 5 * time.Minute
+
+// Resolver is an ima.KeyResolver that fetches keys from a key server, and
+// caches them, including the KeyIds the server has no keys for.
+//
+// A Resolver is safe for concurrent use.
+type Resolver struct {
+	// Base URL of the key server.
+	URL string
+
+	// HTTP Client to make requests with. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// How long to cache keys for.
+	TTL time.Duration
+
+	// If set, only keys with a Certificate that chains to the TrustAnchors
+	// are accepted from the key server, and a response with any other key
+	// in it is refused. If nil, whatever the key server sends is trusted.
+	TrustAnchors *ima.TrustAnchors
+
+	lock  sync.Mutex
+	cache map[[4]byte]cacheEntry
+}
+
+type cacheEntry struct {
+	candidates []ima.Candidate
+	expires    time.Time
+}
+
+// Create a new Resolver for the key server at the base URL, using the
+// http.DefaultClient, and caching keys for the DefaultTTL.
+func NewResolver(url string) *Resolver {
+	return &Resolver{
+		URL:    strings.TrimSuffix(url, "/"),
+		Client: http.DefaultClient,
+		TTL:    DefaultTTL,
+		cache:  map[[4]byte]cacheEntry{},
+	}
+}
+
+// Find the keys with the KeyId, from the cache if they were fetched within
+// the TTL, or from the key server otherwise. Only keys that actually have
+// the KeyId are returned, whatever the server sends back.
+//
+// Errors talking to the server aren't cached.
+func (r *Resolver) Resolve(id [4]byte) ([]ima.Candidate, error) {
+	r.lock.Lock()
+	entry, ok := r.cache[id]
+	r.lock.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.candidates, nil
+	}
+
+	candidates, err := r.fetch(id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.cache == nil {
+		r.cache = map[[4]byte]cacheEntry{}
+	}
+	r.cache[id] = cacheEntry{
+		candidates: candidates,
+		expires:    time.Now().Add(r.TTL),
+	}
+	return candidates, nil
+}
+
+// Forget everything in the cache, so keys will be fetched again.
+func (r *Resolver) Flush() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cache = map[[4]byte]cacheEntry{}
+}
+
+func (r *Resolver) fetch(id [4]byte) ([]ima.Candidate, error) {
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	url := strings.TrimSuffix(r.URL, "/") + "/" + hex.EncodeToString(id[:])
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return []ima.Candidate{}, nil
+	default:
+		return nil, fmt.Errorf("httpkeys: key server returned %s", resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxResponseSize {
+		return nil, fmt.Errorf("httpkeys: key server response is too large")
+	}
+
+	pool := ima.NewKeyPool()
+	if r.TrustAnchors != nil {
+		pool = ima.NewTrustedKeyPool(r.TrustAnchors)
+	}
+	if err := pool.AddBytes(data); err != nil {
+		return nil, fmt.Errorf("httpkeys: bad key server response: %w", err)
+	}
+	return pool.Resolve(id)
+}
+
+// Create an http.Handler that serves the keys from the KeyResolver. The
+// KeyId is taken from the last element of the request path, so the Handler
+// can be mounted anywhere.
+//
+// Keys with a Certificate are served as the Certificate, and bare keys are
+// served in PKIX form, so keys that x509 can't marshal can only be served
+// with a Certificate.
+func Handler(keys ima.KeyResolver) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		raw, err := hex.DecodeString(path.Base(req.URL.Path))
+		if err != nil || len(raw) != 4 {
+			http.Error(w, "bad key id", http.StatusBadRequest)
+			return
+		}
+		var id [4]byte
+		copy(id[:], raw)
+
+		candidates, err := keys.Resolve(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(candidates) == 0 {
+			http.NotFound(w, req)
+			return
+		}
+
+		data := []byte{}
+		for _, candidate := range candidates {
+			block := pem.Block{Type: "CERTIFICATE"}
+			if candidate.Certificate != nil {
+				block.Bytes = candidate.Certificate.Raw
+			} else {
+				block.Type = "PUBLIC KEY"
+				if block.Bytes, err = x509.MarshalPKIXPublicKey(candidate.Key); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			data = append(data, pem.EncodeToMemory(&block)...)
+		}
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Write(data)
+	})
+}
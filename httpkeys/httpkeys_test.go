@@ -0,0 +1,222 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpkeys_test
+
+import (
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+
+	"pault.ag/go/ima"
+	"pault.ag/go/ima/httpkeys"
+)
+
+func TestResolver(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	id, err := ima.PublicKeyId(key.Public())
+	isok(t, err)
+
+	pool := ima.NewKeyPool()
+	isok(t, pool.AddKey(key.Public()))
+
+	var requests int32
+	handler := httpkeys.Handler(pool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		handler.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	resolver := httpkeys.NewResolver(server.URL + "/")
+
+	digest := sha256.Sum256([]byte("totally legit elf af"))
+	sigBytes, err := ima.Sign(key, rand.Reader, digest[:], ima.SHA256.Hash)
+	isok(t, err)
+	sig, err := ima.Parse(sigBytes)
+	isok(t, err)
+
+	opts := ima.VerifyOptions{
+		Digest: digest[:],
+		Hash:   ima.SHA256.Hash,
+		Keys:   resolver,
+	}
+	_, err = sig.Verify(opts)
+	isok(t, err)
+	_, err = sig.Verify(opts)
+	isok(t, err)
+	assert(t, atomic.LoadInt32(&requests) == 1)
+
+	candidates, err := resolver.Resolve([4]byte{1, 2, 3, 4})
+	isok(t, err)
+	assert(t, len(candidates) == 0)
+	_, err = resolver.Resolve([4]byte{1, 2, 3, 4})
+	isok(t, err)
+	assert(t, atomic.LoadInt32(&requests) == 2)
+
+	resolver.Flush()
+	candidates, err = resolver.Resolve(id)
+	isok(t, err)
+	assert(t, len(candidates) == 1)
+	assert(t, atomic.LoadInt32(&requests) == 3)
+
+	resolver.TTL = 0
+	resolver.Flush()
+	_, err = resolver.Resolve(id)
+	isok(t, err)
+	_, err = resolver.Resolve(id)
+	isok(t, err)
+	assert(t, atomic.LoadInt32(&requests) == 5)
+}
+
+func newCert(t *testing.T, key crypto.Signer, template, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	isok(t, err)
+	cert, err := x509.ParseCertificate(der)
+	isok(t, err)
+	return cert
+}
+
+func TestResolverTrustAnchors(t *testing.T) {
+	caKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	ca := newCert(t, caKey, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "ca"},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	anchors, err := ima.NewTrustAnchors(ca)
+	isok(t, err)
+
+	trustedKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	trusted := newCert(t, trustedKey, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "trusted"},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+	trustedId, err := ima.PublicKeyId(trustedKey.Public())
+	isok(t, err)
+
+	untrustedKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	untrustedId, err := ima.PublicKeyId(untrustedKey.Public())
+	isok(t, err)
+
+	pool := ima.NewKeyPool()
+	isok(t, pool.AddCertificate(trusted))
+	isok(t, pool.AddKey(untrustedKey.Public()))
+	server := httptest.NewServer(httpkeys.Handler(pool))
+	defer server.Close()
+
+	resolver := httpkeys.NewResolver(server.URL)
+	candidates, err := resolver.Resolve(untrustedId)
+	isok(t, err)
+	assert(t, len(candidates) == 1)
+
+	resolver = httpkeys.NewResolver(server.URL)
+	resolver.TrustAnchors = anchors
+	candidates, err = resolver.Resolve(trustedId)
+	isok(t, err)
+	assert(t, len(candidates) == 1)
+	_, err = resolver.Resolve(untrustedId)
+	notok(t, err)
+}
+
+func TestResolverLiteral(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	id, err := ima.PublicKeyId(key.Public())
+	isok(t, err)
+
+	pool := ima.NewKeyPool()
+	isok(t, pool.AddKey(key.Public()))
+
+	var requests int32
+	handler := httpkeys.Handler(pool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		handler.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	// No Client and no cache, which the Resolver has to fill in itself.
+	resolver := &httpkeys.Resolver{URL: server.URL + "/", TTL: time.Hour}
+
+	candidates, err := resolver.Resolve(id)
+	isok(t, err)
+	assert(t, len(candidates) == 1)
+	_, err = resolver.Resolve(id)
+	isok(t, err)
+	assert(t, atomic.LoadInt32(&requests) == 1)
+
+	resolver = &httpkeys.Resolver{URL: server.URL}
+	resolver.Flush()
+	candidates, err = resolver.Resolve(id)
+	isok(t, err)
+	assert(t, len(candidates) == 1)
+}
+
+func TestResolverErrors(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	resolver := httpkeys.NewResolver(server.URL)
+	_, err := resolver.Resolve([4]byte{1, 2, 3, 4})
+	notok(t, err)
+	_, err = resolver.Resolve([4]byte{1, 2, 3, 4})
+	notok(t, err)
+	assert(t, atomic.LoadInt32(&requests) == 2)
+
+	_, err = ima.Signature{}.Verify(ima.VerifyOptions{Keys: resolver})
+	notok(t, err)
+	assert(t, err != ima.UnknownSigner)
+}
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(httpkeys.Handler(ima.NewKeyPool()))
+	defer server.Close()
+
+	for path, status := range map[string]int{
+		"/01020304": http.StatusNotFound,
+		"/0102":     http.StatusBadRequest,
+		"/zz":       http.StatusBadRequest,
+	} {
+		resp, err := http.Get(server.URL + path)
+		isok(t, err)
+		resp.Body.Close()
+		assert(t, resp.StatusCode == status)
+	}
+}
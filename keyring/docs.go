@@ -0,0 +1,3 @@
+// Bindings to import IMA keys from, and export them to, the Linux kernel's
+// keyrings, such as .ima or _ima.
+package keyring
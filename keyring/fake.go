@@ -0,0 +1,176 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyring
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"encoding/binary"
+	"encoding/hex"
+
+	"crypto/x509"
+
+	"golang.org/x/sys/unix"
+)
+
+// Fake is an in-memory Keyrings, which behaves like the kernel does as far
+// as IMA is concerned: asymmetric keys are described by their subject and
+// CertificateId, and can't be read back out.
+//
+// This is intended for testing code that uses Keyrings without root, or
+// without a kernel.
+type Fake struct {
+	lock     sync.Mutex
+	next     int32
+	keyrings map[string]int32
+	keys     map[int32]*fakeKey
+}
+
+type fakeKey struct {
+	desc    Description
+	payload []byte
+	links   []int32
+}
+
+// Create a new Fake, without any keyrings.
+func NewFake() *Fake {
+	return &Fake{
+		next:     0x10000000,
+		keyrings: map[string]int32{},
+		keys:     map[int32]*fakeKey{},
+	}
+}
+
+// Create a new, empty keyring, and return its serial number.
+func (f *Fake) NewKeyring(name string) int32 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	id := f.add(Description{Type: "keyring", Perm: 0x3f3f0000, Description: name}, nil)
+	f.keyrings[name] = id
+	return id
+}
+
+// The lock must be held.
+func (f *Fake) add(desc Description, payload []byte) int32 {
+	f.next++
+	f.keys[f.next] = &fakeKey{desc: desc, payload: payload}
+	return f.next
+}
+
+func (f *Fake) Find(name string) (int32, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	id, ok := f.keyrings[name]
+	if !ok {
+		return 0, unix.ENOKEY
+	}
+	return id, nil
+}
+
+func (f *Fake) List(keyring int32) ([]int32, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	key, ok := f.keys[keyring]
+	if !ok {
+		return nil, unix.ENOKEY
+	}
+	if key.desc.Type != "keyring" {
+		return nil, unix.ENOTDIR
+	}
+	return append([]int32{}, key.links...), nil
+}
+
+func (f *Fake) Describe(key int32) (*Description, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	k, ok := f.keys[key]
+	if !ok {
+		return nil, unix.ENOKEY
+	}
+	desc := k.desc
+	return &desc, nil
+}
+
+func (f *Fake) Read(key int32) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	k, ok := f.keys[key]
+	if !ok {
+		return nil, unix.ENOKEY
+	}
+	switch k.desc.Type {
+	case "keyring":
+		data := make([]byte, 4*len(k.links))
+		for i, link := range k.links {
+			binary.NativeEndian.PutUint32(data[i*4:], uint32(link))
+		}
+		return data, nil
+	case "asymmetric":
+		return nil, unix.EOPNOTSUPP
+	default:
+		return append([]byte{}, k.payload...), nil
+	}
+}
+
+func (f *Fake) Add(keyring int32, payload []byte) (int32, error) {
+	cert, err := x509.ParseCertificate(payload)
+	if err != nil {
+		return 0, unix.EBADMSG
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	ring, ok := f.keys[keyring]
+	if !ok {
+		return 0, unix.ENOKEY
+	}
+	if ring.desc.Type != "keyring" {
+		return 0, unix.ENOTDIR
+	}
+
+	id := f.add(Description{
+		Type:        "asymmetric",
+		Perm:        0x3f010000,
+		Description: describeCertificate(cert),
+	}, append([]byte{}, payload...))
+	ring.links = append(ring.links, id)
+	return id, nil
+}
+
+// Describe a Certificate the same way the kernel's x509 key parser does.
+func describeCertificate(cert *x509.Certificate) string {
+	cn, o := cert.Subject.CommonName, ""
+	if len(cert.Subject.Organization) != 0 {
+		o = cert.Subject.Organization[0]
+	}
+
+	var subject string
+	switch {
+	case cn != "" && o != "" && !strings.HasPrefix(cn, o):
+		subject = fmt.Sprintf("%s: %s", o, cn)
+	case cn != "":
+		subject = cn
+	default:
+		subject = o
+	}
+
+	id, err := CertificateId(cert)
+	if err != nil {
+		id = cert.SerialNumber.Bytes()
+	}
+	return fmt.Sprintf("%s: %s", subject, hex.EncodeToString(id))
+}
@@ -0,0 +1,114 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyring
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"encoding/binary"
+
+	"golang.org/x/sys/unix"
+)
+
+// Keyrings of the running kernel, through the keyctl syscalls.
+type Kernel struct{}
+
+// Find the keyring by searching the session and user keyrings, which is
+// where evmctl keeps _ima, and then /proc/keys, which is the only way to
+// find system keyrings such as .ima.
+func (Kernel) Find(name string) (int32, error) {
+	for _, ring := range []int{unix.KEY_SPEC_SESSION_KEYRING, unix.KEY_SPEC_USER_KEYRING} {
+		if id, err := unix.KeyctlSearch(ring, "keyring", name, 0); err == nil {
+			return int32(id), nil
+		}
+	}
+
+	fd, err := os.Open("/proc/keys")
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+
+	// 3d6b1a0e I------     1 perm 1f0b0000     0     0 keyring   .ima: 1
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 9 || fields[7] != "keyring" {
+			continue
+		}
+		if strings.TrimSuffix(fields[8], ":") != name {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil {
+			return 0, err
+		}
+		return int32(id), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("keyring: keyring %q not found", name)
+}
+
+func (k Kernel) List(keyring int32) ([]int32, error) {
+	data, err := k.Read(keyring)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]int32, len(data)/4)
+	for i := range ret {
+		ret[i] = int32(binary.NativeEndian.Uint32(data[i*4:]))
+	}
+	return ret, nil
+}
+
+func (Kernel) Describe(key int32) (*Description, error) {
+	data, err := unix.KeyctlString(unix.KEYCTL_DESCRIBE, int(key))
+	if err != nil {
+		return nil, err
+	}
+	return ParseDescription(data)
+}
+
+func (Kernel) Read(key int32) ([]byte, error) {
+	for {
+		size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, int(key), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size)
+		n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, int(key), data, 0)
+		if err != nil {
+			return nil, err
+		}
+		// The key may have grown between the two calls.
+		if n <= size {
+			return data[:n], nil
+		}
+	}
+}
+
+func (Kernel) Add(keyring int32, payload []byte) (int32, error) {
+	id, err := unix.AddKey("asymmetric", "", payload, int(keyring))
+	if err != nil {
+		return 0, err
+	}
+	return int32(id), nil
+}
@@ -0,0 +1,241 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyring
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"encoding/asn1"
+	"encoding/hex"
+
+	"crypto/x509"
+
+	"pault.ag/go/ima"
+)
+
+// Keyrings is the part of the kernel's key management API needed to move
+// IMA keys in and out of the kernel. Kernel talks to the running kernel,
+// and Fake can be used to test without root, or without a kernel at all.
+type Keyrings interface {
+	// Find the serial number of a keyring by name, such as ".ima" or "_ima".
+	Find(name string) (int32, error)
+
+	// List the serial numbers of the keys linked into a keyring.
+	List(keyring int32) ([]int32, error)
+
+	// Describe a key.
+	Describe(key int32) (*Description, error)
+
+	// Read the payload of a key. Many key types, including the asymmetric
+	// keys used by IMA, can't be read, which is an error.
+	Read(key int32) ([]byte, error)
+
+	// Add an asymmetric key to a keyring, from the DER encoded X.509
+	// Certificate in the payload, and return its serial number.
+	Add(keyring int32, payload []byte) (int32, error)
+}
+
+// Description of a key, as returned by keyctl describe.
+type Description struct {
+	Type        string
+	UID         uint32
+	GID         uint32
+	Perm        uint32
+	Description string
+}
+
+// Parse the output of KEYCTL_DESCRIBE, which is of the form
+// "type;uid;gid;perm;description".
+func ParseDescription(data string) (*Description, error) {
+	parts := strings.SplitN(strings.TrimRight(data, "\x00"), ";", 5)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("keyring: bad key description %q", data)
+	}
+	uid, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	perm, err := strconv.ParseUint(parts[3], 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	return &Description{
+		Type:        parts[0],
+		UID:         uint32(uid),
+		GID:         uint32(gid),
+		Perm:        uint32(perm),
+		Description: parts[4],
+	}, nil
+}
+
+// Get the Subject Key Identifier of an asymmetric key from its Description.
+// The kernel describes X.509 keys as "subject: skid", where skid is the hex
+// encoded Subject Key Identifier, or for Certificates without one, the hex
+// encoded serial number; see CertificateId.
+func (d Description) SubjectKeyId() ([]byte, error) {
+	if d.Type != "asymmetric" {
+		return nil, fmt.Errorf("keyring: %q is not an asymmetric key", d.Description)
+	}
+	i := strings.LastIndex(d.Description, ": ")
+	if i < 0 {
+		return nil, fmt.Errorf("keyring: no key id in %q", d.Description)
+	}
+	skid, err := hex.DecodeString(d.Description[i+2:])
+	if err != nil || len(skid) == 0 {
+		return nil, fmt.Errorf("keyring: no key id in %q", d.Description)
+	}
+	return skid, nil
+}
+
+// Get the IMA Key ID of an asymmetric key from its Description, which is the
+// last 4 bytes of the SubjectKeyId, just like ima.CertificateKeyId.
+func (d Description) KeyId() ([4]byte, error) {
+	skid, err := d.SubjectKeyId()
+	if err != nil {
+		return [4]byte{}, err
+	}
+	if len(skid) < 4 {
+		return [4]byte{}, fmt.Errorf("keyring: key id in %q is too short", d.Description)
+	}
+	var id [4]byte
+	copy(id[:], skid[len(skid)-4:])
+	return id, nil
+}
+
+// Get the identifier the kernel puts at the end of the Description of a
+// Certificate: its Subject Key Identifier, or if it doesn't have one, its
+// serial number, exactly as it's encoded in the Certificate. This can have
+// a leading zero byte that the parsed SerialNumber doesn't.
+func CertificateId(cert *x509.Certificate) ([]byte, error) {
+	if len(cert.SubjectKeyId) != 0 {
+		return cert.SubjectKeyId, nil
+	}
+	tbs := struct {
+		Version int `asn1:"optional,explicit,default:0,tag:0"`
+		Serial  asn1.RawValue
+	}{}
+	if _, err := asn1.Unmarshal(cert.RawTBSCertificate, &tbs); err != nil {
+		return nil, err
+	}
+	if tbs.Serial.Tag != asn1.TagInteger || len(tbs.Serial.Bytes) == 0 {
+		return nil, fmt.Errorf("keyring: bad certificate serial number")
+	}
+	return tbs.Serial.Bytes, nil
+}
+
+// List of keys in a kernel keyring that could not be imported.
+type UnmatchedKeys []Description
+
+func (u UnmatchedKeys) Error() string {
+	names := []string{}
+	for _, desc := range u {
+		names = append(names, strconv.Quote(desc.Description))
+	}
+	return fmt.Sprintf("keyring: no certificate found for %s", strings.Join(names, ", "))
+}
+
+// Build a KeyPool of the asymmetric keys in the named kernel keyring.
+//
+// The kernel doesn't allow asymmetric keys to be read back out, so keys
+// which can't be read are matched by the id in their Description against
+// the CertificateId of the Certificates in the candidates KeyPool, such as
+// the contents of /etc/keys/ima. This makes the returned KeyPool hold
+// exactly the keys the kernel trusts, as long as all of them are among the
+// candidates.
+//
+// Keys in the kernel keyring without a matching candidate are left out, and
+// returned as an UnmatchedKeys error, along with the rest of the KeyPool.
+func Import(keyrings Keyrings, name string, candidates ima.KeyPool) (ima.KeyPool, error) {
+	pool := ima.NewKeyPool()
+
+	keyring, err := keyrings.Find(name)
+	if err != nil {
+		return pool, err
+	}
+	keys, err := keyrings.List(keyring)
+	if err != nil {
+		return pool, err
+	}
+
+	certs := map[string][]*x509.Certificate{}
+	for _, cert := range candidates.Certificates() {
+		id, err := CertificateId(cert)
+		if err != nil {
+			continue
+		}
+		certs[string(id)] = append(certs[string(id)], cert)
+	}
+
+	unmatched := UnmatchedKeys{}
+	for _, key := range keys {
+		desc, err := keyrings.Describe(key)
+		if err != nil {
+			return pool, err
+		}
+		if desc.Type != "asymmetric" {
+			continue
+		}
+
+		if payload, err := keyrings.Read(key); err == nil {
+			if cert, err := x509.ParseCertificate(payload); err == nil {
+				if err := pool.AddCertificate(cert); err != nil {
+					return pool, err
+				}
+				continue
+			}
+		}
+
+		skid, err := desc.SubjectKeyId()
+		if err != nil || len(certs[string(skid)]) == 0 {
+			unmatched = append(unmatched, *desc)
+			continue
+		}
+		for _, cert := range certs[string(skid)] {
+			if err := pool.AddCertificate(cert); err != nil {
+				return pool, err
+			}
+		}
+	}
+
+	if len(unmatched) != 0 {
+		return pool, unmatched
+	}
+	return pool, nil
+}
+
+// Add the Certificates in the KeyPool to the named kernel keyring. Keys that
+// were added to the KeyPool without a Certificate are skipped, since the
+// kernel only accepts X.509 Certificates for IMA keys.
+//
+// The kernel may refuse Certificates that aren't signed by a key it trusts,
+// depending on the restrictions on the keyring.
+func Export(keyrings Keyrings, name string, pool ima.KeyPool) error {
+	keyring, err := keyrings.Find(name)
+	if err != nil {
+		return err
+	}
+	for _, cert := range pool.Certificates() {
+		if _, err := keyrings.Add(keyring, cert.Raw); err != nil {
+			return fmt.Errorf("keyring: adding %q: %w", cert.Subject, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyring_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+
+	"golang.org/x/sys/unix"
+
+	"pault.ag/go/ima"
+	"pault.ag/go/ima/keyring"
+)
+
+func newCertificate(t *testing.T, name string, skid []byte) *x509.Certificate {
+	return newCertificateSerial(t, name, skid, big.NewInt(time.Now().UnixNano()))
+}
+
+func newCertificateSerial(t *testing.T, name string, skid []byte, serial *big.Int) *x509.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	isok(t, err)
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"ima"}},
+		SubjectKeyId: skid,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	isok(t, err)
+	cert, err := x509.ParseCertificate(der)
+	isok(t, err)
+	return cert
+}
+
+func TestParseDescription(t *testing.T) {
+	desc, err := keyring.ParseDescription("asymmetric;0;0;3f010000;ima: test: 0a0b0c0d0e0f")
+	isok(t, err)
+	assert(t, desc.Type == "asymmetric")
+	assert(t, desc.Perm == 0x3f010000)
+	assert(t, desc.Description == "ima: test: 0a0b0c0d0e0f")
+
+	id, err := desc.KeyId()
+	isok(t, err)
+	assert(t, id == [4]byte{0x0c, 0x0d, 0x0e, 0x0f})
+
+	_, err = keyring.ParseDescription("asymmetric;0;0")
+	notok(t, err)
+
+	desc, err = keyring.ParseDescription("keyring;0;0;3f030000;.ima")
+	isok(t, err)
+	_, err = desc.KeyId()
+	notok(t, err)
+}
+
+func TestImportExportFake(t *testing.T) {
+	first := newCertificate(t, "first", []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	second := newCertificate(t, "second", []byte{8, 7, 6, 5, 4, 3, 2, 1})
+	other := newCertificate(t, "other", []byte{1, 1, 1, 1, 1, 1, 1, 1})
+
+	fake := keyring.NewFake()
+	fake.NewKeyring(".ima")
+
+	trusted := ima.NewKeyPool()
+	isok(t, trusted.AddCertificate(first))
+	isok(t, trusted.AddCertificate(second))
+	isok(t, keyring.Export(fake, ".ima", trusted))
+	notok(t, keyring.Export(fake, "_ima", trusted))
+
+	candidates := ima.NewKeyPool()
+	isok(t, candidates.AddCertificate(first))
+	isok(t, candidates.AddCertificate(second))
+	isok(t, candidates.AddCertificate(other))
+
+	pool, err := keyring.Import(fake, ".ima", candidates)
+	isok(t, err)
+	assert(t, pool.Len() == 2)
+	assert(t, pool.Certificates()[0] == first)
+	assert(t, pool.Certificates()[1] == second)
+
+	candidates = ima.NewKeyPool()
+	isok(t, candidates.AddCertificate(first))
+	pool, err = keyring.Import(fake, ".ima", candidates)
+	var unmatched keyring.UnmatchedKeys
+	assert(t, errors.As(err, &unmatched))
+	assert(t, len(unmatched) == 1)
+	assert(t, unmatched[0].Description == "ima: second: 0807060504030201")
+	assert(t, pool.Len() == 1)
+}
+
+func TestImportNoSubjectKeyId(t *testing.T) {
+	// The kernel describes these by their serial number, as it's encoded,
+	// which has a leading zero byte here.
+	serial, ok := new(big.Int).SetString("8badf00d", 16)
+	assert(t, ok)
+	cert := newCertificateSerial(t, "no skid", nil, serial)
+	assert(t, len(cert.SubjectKeyId) == 0)
+	short := newCertificateSerial(t, "short", nil, big.NewInt(7))
+
+	id, err := keyring.CertificateId(cert)
+	isok(t, err)
+	assert(t, bytes.Equal(id, []byte{0x00, 0x8b, 0xad, 0xf0, 0x0d}))
+
+	fake := keyring.NewFake()
+	fake.NewKeyring(".ima")
+	trusted := ima.NewKeyPool()
+	isok(t, trusted.AddCertificate(cert))
+	isok(t, trusted.AddCertificate(short))
+	isok(t, keyring.Export(fake, ".ima", trusted))
+
+	pool, err := keyring.Import(fake, ".ima", trusted)
+	isok(t, err)
+	assert(t, pool.Len() == 2)
+	assert(t, pool.Certificates()[0] == cert)
+	assert(t, pool.Certificates()[1] == short)
+
+	pool, err = keyring.Import(fake, ".ima", ima.NewKeyPool())
+	var unmatched keyring.UnmatchedKeys
+	assert(t, errors.As(err, &unmatched))
+	assert(t, len(unmatched) == 2)
+	assert(t, unmatched[0].Description == "ima: no skid: 008badf00d")
+	assert(t, unmatched[1].Description == "ima: short: 07")
+	assert(t, pool.Len() == 0)
+}
+
+func TestImportExportKernel(t *testing.T) {
+	name := fmt.Sprintf("ima-test-%d", os.Getpid())
+	ring, err := unix.AddKey("keyring", name, nil, unix.KEY_SPEC_SESSION_KEYRING)
+	switch err {
+	case nil:
+	case unix.EPERM, unix.EACCES, unix.ENOSYS:
+		t.Skipf("kernel keyrings are not available: %s", err)
+	default:
+		isok(t, err)
+	}
+	defer unix.KeyctlInt(unix.KEYCTL_INVALIDATE, ring, 0, 0, 0)
+
+	cert := newCertificate(t, "kernel", []byte{9, 9, 9, 9, 1, 2, 3, 4})
+	pool := ima.NewKeyPool()
+	isok(t, pool.AddCertificate(cert))
+
+	kernel := keyring.Kernel{}
+	err = keyring.Export(kernel, name, pool)
+	switch {
+	case err == nil:
+	case errors.Is(err, unix.EPERM), errors.Is(err, unix.ENOSYS),
+		errors.Is(err, unix.ENODEV), errors.Is(err, unix.EBADMSG):
+		t.Skipf("kernel can't load asymmetric keys: %s", err)
+	default:
+		isok(t, err)
+	}
+
+	imported, err := keyring.Import(kernel, name, pool)
+	isok(t, err)
+	assert(t, imported.Len() == 1)
+	assert(t, len(imported.Get([4]byte{1, 2, 3, 4})) == 1)
+}
@@ -0,0 +1,227 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ima
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"encoding/pem"
+
+	"crypto"
+	"crypto/x509"
+)
+
+// Error loading keys from a single file.
+type LoadError struct {
+	Path string
+	Err  error
+}
+
+func (e LoadError) Error() string {
+	return fmt.Sprintf("ima: %s: %s", e.Path, e.Err)
+}
+
+func (e LoadError) Unwrap() error {
+	return e.Err
+}
+
+// List of errors from loading keys, one per file that could not be loaded.
+type LoadErrors []LoadError
+
+func (e LoadErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e[0].Error(), len(e)-1)
+}
+
+// Load certificates and public keys into the KeyPool from each of the paths,
+// which may be a file, a directory, or a glob pattern. Directories are not
+// loaded recursively, which matches how evmctl and the kernel treat
+// directories such as /etc/keys/ima.
+//
+// Files may be DER encoded X.509 Certificates, PKIX or PKCS#1 public keys, or
+// PEM files containing any number of those. A file is loaded entirely or not
+// at all.
+//
+// Files that can't be loaded don't stop the rest from being loaded. If any
+// fail, a LoadErrors with an entry for each failed file is returned.
+func (k KeyPool) Load(paths ...string) error {
+	return loadFiles(paths, k.LoadFile)
+}
+
+// Call load on every file that paths refer to, collecting any errors.
+func loadFiles(paths []string, load func(string) error) error {
+	errs := LoadErrors{}
+	for _, path := range paths {
+		files, err := expandPath(path)
+		if err != nil {
+			errs = append(errs, LoadError{Path: path, Err: err})
+			continue
+		}
+		for _, file := range files {
+			if err := load(file); err != nil {
+				errs = append(errs, LoadError{Path: file, Err: err})
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// Turn a path into the list of files it refers to.
+func expandPath(path string) ([]string, error) {
+	if strings.ContainsAny(path, "*?[") {
+		files, err := filepath.Glob(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no files match")
+		}
+		ret := []string{}
+		for _, file := range files {
+			expanded, err := expandPath(file)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, expanded...)
+		}
+		return ret, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		ret = append(ret, filepath.Join(path, entry.Name()))
+	}
+	return ret, nil
+}
+
+// Load certificates and public keys from a single file into the KeyPool. See
+// Load for the formats that are understood.
+func (k KeyPool) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return k.AddBytes(data)
+}
+
+// Load certificates and public keys from the contents of a file into the
+// KeyPool. See Load for the formats that are understood. If any of the keys
+// can't be added, none of them are.
+func (k KeyPool) AddBytes(data []byte) error {
+	keys, err := parseKeys(data)
+	if err != nil {
+		return err
+	}
+
+	// Check every key before adding any, so a file is never half loaded.
+	entries := make([]keyEntry, len(keys))
+	for i, key := range keys {
+		var err error
+		switch key := key.(type) {
+		case *x509.Certificate:
+			entries[i], err = k.certificateEntry(key)
+		default:
+			entries[i], err = k.keyEntry(key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	k.add(entries...)
+	return nil
+}
+
+// Parse the contents of a file into a list of *x509.Certificate and
+// crypto.PublicKey values.
+func parseKeys(data []byte) ([]interface{}, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN ")) {
+		key, err := parseDER(data)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{key}, nil
+	}
+
+	keys := []interface{}{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key, err := parsePEMBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PEM blocks found")
+	}
+	return keys, nil
+}
+
+// Parse a PEM block into either an *x509.Certificate or a crypto.PublicKey.
+func parsePEMBlock(block *pem.Block) (interface{}, error) {
+	switch block.Type {
+	case "CERTIFICATE":
+		return x509.ParseCertificate(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unknown PEM block type %q", block.Type)
+	}
+}
+
+// Parse DER data into either an *x509.Certificate or a crypto.PublicKey.
+func parseDER(data []byte) (interface{}, error) {
+	if cert, err := x509.ParseCertificate(data); err == nil {
+		return cert, nil
+	}
+	if key, err := x509.ParsePKIXPublicKey(data); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(data); err == nil {
+		return crypto.PublicKey(key), nil
+	}
+	return nil, fmt.Errorf("not a DER encoded certificate or public key")
+}
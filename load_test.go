@@ -0,0 +1,110 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ima_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"encoding/pem"
+
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+
+	"pault.ag/go/ima"
+)
+
+func TestKeyPoolLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ima")
+	isok(t, err)
+	defer os.RemoveAll(dir)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	isok(t, err)
+	pemKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+
+	pkixKey, err := x509.MarshalPKIXPublicKey(pemKey.Public())
+	isok(t, err)
+	pemData := append(
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkixKey}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: newTestKeyCert(t, nil, ecdsaKey, x509.Certificate{}).cert.Raw})...,
+	)
+	pemData = append(pemData, []byte("trailing garbage\n")...)
+
+	isok(t, os.Mkdir(filepath.Join(dir, "ima"), 0755))
+	isok(t, ioutil.WriteFile(filepath.Join(dir, "ima", "rsa.der"), newTestKeyCert(t, nil, rsaKey, x509.Certificate{}).cert.Raw, 0644))
+	isok(t, ioutil.WriteFile(filepath.Join(dir, "ima", "bad.der"), []byte("not a key"), 0644))
+	isok(t, ioutil.WriteFile(filepath.Join(dir, "keys.pem"), pemData, 0644))
+
+	pool := ima.NewKeyPool()
+	err = pool.Load(filepath.Join(dir, "ima"), filepath.Join(dir, "*.pem"), filepath.Join(dir, "missing"))
+	notok(t, err)
+	loadErrs, ok := err.(ima.LoadErrors)
+	assert(t, ok)
+	assert(t, len(loadErrs) == 2)
+	assert(t, loadErrs[0].Path == filepath.Join(dir, "ima", "bad.der"))
+	assert(t, loadErrs[1].Path == filepath.Join(dir, "missing"))
+
+	assert(t, pool.Len() == 3)
+	assert(t, pool.MaybeContains(rsaKey.Public()))
+	assert(t, pool.MaybeContains(ecdsaKey.Public()))
+	assert(t, pool.MaybeContains(pemKey.Public()))
+}
+
+func TestKeyPoolLoadBadPEM(t *testing.T) {
+	pool := ima.NewKeyPool()
+	notok(t, pool.AddBytes(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{0x00}})))
+	notok(t, pool.AddBytes([]byte("-----BEGIN nothing")))
+	assert(t, pool.Len() == 0)
+
+	notok(t, pool.Load("/nonexistent/*.pem"))
+}
+
+func TestKeyPoolLoadMixed(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	rsaDer, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	isok(t, err)
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	isok(t, err)
+	edDer, err := x509.MarshalPKIXPublicKey(edKey)
+	isok(t, err)
+
+	data := append(
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaDer}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: edDer})...,
+	)
+
+	pool := ima.NewKeyPool()
+	notok(t, pool.AddBytes(data))
+	assert(t, pool.Len() == 0)
+
+	dir, err := ioutil.TempDir("", "ima-keys")
+	isok(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mixed.pem")
+	isok(t, ioutil.WriteFile(path, data, 0644))
+	notok(t, pool.Load(path))
+	assert(t, pool.Len() == 0)
+}
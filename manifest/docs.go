@@ -0,0 +1,15 @@
+// Signing manifests, for signing files on a machine that can't see them,
+// such as an air-gapped signing host.
+//
+// A Manifest of file digests is made from a tree with Walk, carried to the
+// signing host, signed with Manifest.Sign, and carried back to be put onto
+// the tree with Manifest.Apply, which checks each file still has the digest
+// that was signed.
+//
+// Manifests are text, with one file per line, of the form:
+//
+//	sha256 <hex digest> <hex signature, or - if unsigned> <path>
+//
+// Paths are relative to the root of the tree, and lines starting with '#'
+// are ignored.
+package manifest
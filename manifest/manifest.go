@@ -0,0 +1,285 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"encoding/hex"
+
+	"crypto"
+
+	"pault.ag/go/ima"
+)
+
+// A file in a Manifest.
+type Entry struct {
+	// Path of the file, relative to the root of the tree, with forward
+	// slashes.
+	Path string
+
+	// Hash function the file was measured with.
+	Hash ima.Hash
+
+	// Digest of the file.
+	Digest []byte
+
+	// Serialized IMA Signature over the Digest, or nil if the Entry hasn't
+	// been signed yet.
+	Signature []byte
+}
+
+// List of files and their digests, and maybe Signatures.
+type Manifest []Entry
+
+// Error for a single file in a Manifest.
+type Error struct {
+	Path string
+	Err  error
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("manifest: %s: %s", e.Path, e.Err)
+}
+
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// List of Errors, one for each file in a Manifest that failed.
+type Errors []Error
+
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e[0].Error(), len(e)-1)
+}
+
+// Measure a file with the Hash function.
+func measure(path string, imaHash ima.Hash) ([]byte, error) {
+	hash, err := imaHash.New()
+	if err != nil {
+		return nil, err
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	if _, err := io.Copy(hash, fd); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// Walk the tree at root, and measure every regular file with the Hash
+// function. Symlinks, devices and the like are left out, since IMA only
+// appraises regular files.
+func Walk(root string, hash ima.Hash) (Manifest, error) {
+	ret := Manifest{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		digest, err := measure(path, hash)
+		if err != nil {
+			return err
+		}
+		ret = append(ret, Entry{
+			Path:   filepath.ToSlash(rel),
+			Hash:   hash,
+			Digest: digest,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Parse a Manifest.
+func Parse(r io.Reader) (Manifest, error) {
+	ret := Manifest{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, " ", 4)
+		if len(fields) != 4 || fields[3] == "" {
+			return nil, fmt.Errorf("manifest: line %d: expected hash, digest, signature and path", line)
+		}
+
+		imaHash, err := ima.HashFunctions.ByName(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("manifest: line %d: unknown hash %q", line, fields[0])
+		}
+		digest, err := hex.DecodeString(fields[1])
+		if err != nil || len(digest) != imaHash.Size {
+			return nil, fmt.Errorf("manifest: line %d: bad %s digest", line, imaHash.Name)
+		}
+		var signature []byte
+		if fields[2] != "-" {
+			if signature, err = hex.DecodeString(fields[2]); err != nil {
+				return nil, fmt.Errorf("manifest: line %d: bad signature: %s", line, err)
+			}
+		}
+		ret = append(ret, Entry{
+			Path:      fields[3],
+			Hash:      *imaHash,
+			Digest:    digest,
+			Signature: signature,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Write the Manifest out, in the format read by Parse.
+func (m Manifest) Write(w io.Writer) error {
+	for _, entry := range m {
+		if strings.ContainsAny(entry.Path, "\n\r") {
+			return Error{Path: entry.Path, Err: fmt.Errorf("paths can't contain newlines")}
+		}
+		signature := "-"
+		if entry.Signature != nil {
+			signature = hex.EncodeToString(entry.Signature)
+		}
+		if _, err := fmt.Fprintf(w, "%s %x %s %s\n", entry.Hash.Name, entry.Digest, signature, entry.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sign the digest of every Entry, and return a new Manifest with the
+// Signatures set. Each Entry is signed with its own Hash function, and the
+// rest of the SignatureOptions, such as the Version, are taken from opts.
+func (m Manifest) Sign(signer crypto.Signer, rand io.Reader, opts ima.SignatureOptions) (Manifest, error) {
+	ret := make(Manifest, len(m))
+	for i, entry := range m {
+		entryOpts := opts
+		entryOpts.Hash = entry.Hash
+		signature, err := ima.Sign(signer, rand, entry.Digest, entryOpts)
+		if err != nil {
+			return nil, Error{Path: entry.Path, Err: err}
+		}
+		entry.Signature = signature
+		ret[i] = entry
+	}
+	return ret, nil
+}
+
+// Put the Signatures in the Manifest onto the tree at root, by calling write
+// with each file and its Signature; xattr.Write and xattr.WriteSidecar can
+// both be used. Every file is measured again first, and is only written to
+// if it still has the Digest that was signed.
+//
+// Files are opened beneath root without following symlinks out of it, so
+// paths which would escape the root are refused, as are symlinks, anything
+// that isn't a regular file, and Entries without a Signature. Files that
+// fail don't stop the rest from being written; if any fail, an Errors with
+// an entry for each failed file is returned.
+func (m Manifest) Apply(root string, write func(fd *os.File, signature []byte) error) error {
+	dir, err := os.OpenRoot(root)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	errs := Errors{}
+	for _, entry := range m {
+		if err := entry.apply(dir, write); err != nil {
+			errs = append(errs, Error{Path: entry.Path, Err: err})
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+func (e Entry) apply(root *os.Root, write func(fd *os.File, signature []byte) error) error {
+	if e.Signature == nil {
+		return fmt.Errorf("not signed")
+	}
+	sig, err := ima.Parse(e.Signature)
+	if err != nil {
+		return err
+	}
+	if sig.Header.HashAlgorithm != e.Hash.Id {
+		return fmt.Errorf("signature is over a different hash than the %s digest", e.Hash.Name)
+	}
+
+	rel := filepath.FromSlash(e.Path)
+	if !filepath.IsLocal(rel) {
+		return fmt.Errorf("path is outside of the root")
+	}
+
+	// The Root refuses to resolve anything outside of it, even through a
+	// symlinked directory, so whatever this opens is beneath the root.
+	fd, err := root.Open(rel)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	info, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("not a regular file")
+	}
+	// Walk never records symlinks, so don't write through one that has
+	// since been put in a file's place.
+	linfo, err := root.Lstat(rel)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(info, linfo) {
+		return fmt.Errorf("not a regular file")
+	}
+
+	hash, err := e.Hash.New()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(hash, fd); err != nil {
+		return err
+	}
+	if !bytes.Equal(hash.Sum(nil), e.Digest) {
+		return fmt.Errorf("digest has changed since the manifest was made")
+	}
+	return write(fd, e.Signature)
+}
@@ -0,0 +1,242 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+
+	"pault.ag/go/ima"
+	"pault.ag/go/ima/manifest"
+	"pault.ag/go/ima/xattr"
+)
+
+func makeTree(t *testing.T) string {
+	root, err := ioutil.TempDir("", "ima-manifest")
+	isok(t, err)
+	isok(t, os.Mkdir(filepath.Join(root, "bin"), 0755))
+	isok(t, ioutil.WriteFile(filepath.Join(root, "bin", "true"), []byte("totally legit elf af"), 0644))
+	isok(t, ioutil.WriteFile(filepath.Join(root, "README"), []byte("read me"), 0644))
+	isok(t, os.Symlink("bin/true", filepath.Join(root, "true")))
+	return root
+}
+
+func TestWalkRoundTrip(t *testing.T) {
+	root := makeTree(t)
+	defer os.RemoveAll(root)
+
+	m, err := manifest.Walk(root, ima.SHA256)
+	isok(t, err)
+	assert(t, len(m) == 2)
+	assert(t, m[0].Path == "README")
+	assert(t, m[1].Path == "bin/true")
+	assert(t, m[1].Signature == nil)
+
+	buf := bytes.Buffer{}
+	isok(t, m.Write(&buf))
+	parsed, err := manifest.Parse(&buf)
+	isok(t, err)
+	assert(t, len(parsed) == 2)
+	assert(t, parsed[1].Path == "bin/true")
+	assert(t, parsed[1].Hash.Id == ima.SHA256.Id)
+	assert(t, bytes.Equal(parsed[1].Digest, m[1].Digest))
+	assert(t, parsed[1].Signature == nil)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{
+		"sha256 00 -\n",
+		"md4 00 - file\n",
+		"sha256 00 - file\n",
+		"sha1 " + string(bytes.Repeat([]byte("00"), 20)) + " zz file\n",
+	} {
+		_, err := manifest.Parse(bytes.NewBufferString(text))
+		notok(t, err)
+	}
+
+	m, err := manifest.Parse(bytes.NewBufferString(
+		"# comment\n\nsha1 " + string(bytes.Repeat([]byte("00"), 20)) + " - a file with spaces\n",
+	))
+	isok(t, err)
+	assert(t, len(m) == 1)
+	assert(t, m[0].Path == "a file with spaces")
+}
+
+func TestSignApply(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	root := makeTree(t)
+	defer os.RemoveAll(root)
+
+	m, err := manifest.Walk(root, ima.SHA256)
+	isok(t, err)
+	signed, err := m.Sign(key, rand.Reader, ima.SignatureOptions{})
+	isok(t, err)
+	assert(t, m[0].Signature == nil)
+	assert(t, signed[0].Signature != nil)
+
+	isok(t, signed.Apply(root, xattr.WriteSidecar))
+
+	keys := ima.NewKeyPool()
+	isok(t, keys.AddKey(key.Public()))
+	for _, entry := range signed {
+		fd, err := os.Open(filepath.Join(root, entry.Path))
+		isok(t, err)
+		isok(t, xattr.VerifySidecar(fd, keys))
+		fd.Close()
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	root := makeTree(t)
+	defer os.RemoveAll(root)
+
+	m, err := manifest.Walk(root, ima.SHA256)
+	isok(t, err)
+	signed, err := m.Sign(key, rand.Reader, ima.SignatureOptions{Hash: ima.SHA256})
+	isok(t, err)
+
+	escape := signed[0]
+	escape.Path = "../README"
+	link := signed[1]
+	link.Path = "true"
+	signed = append(signed, escape, link, m[0])
+
+	// Change a file after it was measured.
+	isok(t, ioutil.WriteFile(filepath.Join(root, "bin", "true"), []byte("evil"), 0644))
+
+	written := []string{}
+	err = signed.Apply(root, func(fd *os.File, signature []byte) error {
+		written = append(written, fd.Name())
+		return nil
+	})
+	notok(t, err)
+
+	errs := manifest.Errors{}
+	assert(t, errors.As(err, &errs))
+	assert(t, len(errs) == 4)
+	assert(t, errs[0].Path == "bin/true")
+	assert(t, errs[1].Path == "../README")
+	assert(t, errs[2].Path == "true")
+	assert(t, errs[3].Path == "README")
+
+	assert(t, len(written) == 1)
+	assert(t, written[0] == filepath.Join(root, "README"))
+}
+
+func TestApplyHashMismatch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	root := makeTree(t)
+	defer os.RemoveAll(root)
+
+	m, err := manifest.Walk(root, ima.SHA256)
+	isok(t, err)
+	signed, err := m.Sign(key, rand.Reader, ima.SignatureOptions{Hash: ima.SHA256})
+	isok(t, err)
+
+	// The files haven't changed, but the Signatures were made over SHA256
+	// digests, and the Entries say SHA512.
+	m, err = manifest.Walk(root, ima.SHA512)
+	isok(t, err)
+	assert(t, len(m) == len(signed))
+	for i := range m {
+		assert(t, m[i].Path == signed[i].Path)
+		m[i].Signature = signed[i].Signature
+	}
+	m = append(m, manifest.Entry{
+		Path:      signed[0].Path,
+		Hash:      signed[0].Hash,
+		Digest:    signed[0].Digest,
+		Signature: []byte("Totally real signature no tricks"),
+	})
+
+	written := 0
+	err = m.Apply(root, func(fd *os.File, signature []byte) error {
+		written++
+		return nil
+	})
+	notok(t, err)
+
+	errs := manifest.Errors{}
+	assert(t, errors.As(err, &errs))
+	assert(t, len(errs) == len(m))
+	assert(t, written == 0)
+}
+
+func TestApplySymlinks(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	root := makeTree(t)
+	defer os.RemoveAll(root)
+	outside := makeTree(t)
+	defer os.RemoveAll(outside)
+
+	// A directory inside the root which points out of it.
+	isok(t, os.Symlink(outside, filepath.Join(root, "etc")))
+
+	m, err := manifest.Walk(outside, ima.SHA256)
+	isok(t, err)
+	signed, err := m.Sign(key, rand.Reader, ima.SignatureOptions{Hash: ima.SHA256})
+	isok(t, err)
+
+	escape := signed[0]
+	escape.Path = "etc/README"
+	link := signed[1]
+	link.Path = "true"
+	signed = manifest.Manifest{escape, link}
+
+	written := []string{}
+	err = signed.Apply(root, func(fd *os.File, signature []byte) error {
+		written = append(written, fd.Name())
+		return nil
+	})
+	notok(t, err)
+
+	errs := manifest.Errors{}
+	assert(t, errors.As(err, &errs))
+	assert(t, len(errs) == 2)
+	assert(t, errs[0].Path == "etc/README")
+	assert(t, errs[1].Path == "true")
+	assert(t, len(written) == 0)
+}
+
+func TestSignHash(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	root := makeTree(t)
+	defer os.RemoveAll(root)
+
+	m, err := manifest.Walk(root, ima.SHA512)
+	isok(t, err)
+	signed, err := m.Sign(key, rand.Reader, ima.SignatureOptions{Hash: ima.SHA256})
+	isok(t, err)
+
+	sig, err := ima.Parse(signed[0].Signature)
+	isok(t, err)
+	hash, err := sig.Header.Algorithm()
+	isok(t, err)
+	assert(t, hash.Hash == crypto.SHA512)
+}
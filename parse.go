@@ -23,14 +23,24 @@ import (
 	"encoding/binary"
 
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+
+	"pault.ag/go/ima/ecrdsa"
+	"pault.ag/go/ima/sm2"
 )
 
 // IMA creates a content-based ID to help validate signatures which is based
 // on a hash the public key. In particular, it's the last 4 bytes of a SHA1
-// hash of the DER encoded RSA Public Key. Other formates are not supported
-// at this time.
+// hash of the subjectPublicKey of the key, which is the DER encoded RSA Public
+// Key for RSA keys, the uncompressed point for ECDSA and SM2 keys, or the
+// OCTET STRING of the little endian point for EC-RDSA keys. This matches the
+// Subject Key Identifier most tools will generate for a certificate.
+//
+// Only RSA, ECDSA, SM2 and EC-RDSA keys are supported at this time.
 func PublicKeyId(pubKey crypto.PublicKey) ([4]byte, error) {
 	derKey := []byte{}
 	switch pubKey.(type) {
@@ -44,6 +54,19 @@ func PublicKeyId(pubKey crypto.PublicKey) ([4]byte, error) {
 		if err != nil {
 			return [4]byte{}, err
 		}
+	case *sm2.PublicKey:
+		derKey = pubKey.(*sm2.PublicKey).Bytes()
+	case *ecrdsa.PublicKey:
+		derKey = pubKey.(*ecrdsa.PublicKey).Bytes()
+	case ecdsa.PublicKey:
+		pubKey := pubKey.(ecdsa.PublicKey)
+		return PublicKeyId(&pubKey)
+	case *ecdsa.PublicKey:
+		var err error
+		derKey, err = subjectPublicKey(pubKey)
+		if err != nil {
+			return [4]byte{}, err
+		}
 	default:
 		return [4]byte{}, fmt.Errorf("ima: public key format not supported")
 	}
@@ -55,6 +78,54 @@ func PublicKeyId(pubKey crypto.PublicKey) ([4]byte, error) {
 	return [4]byte{hashSum[16], hashSum[17], hashSum[18], hashSum[19]}, nil
 }
 
+// The kernel doesn't compute Key IDs itself, rather it matches the Key ID
+// against the last 4 bytes of the Subject Key Identifier of the Certificate
+// the key was loaded from. Most of the time, this will be the same as the
+// PublicKeyId, but a CA is free to generate the Subject Key Identifier however
+// it pleases.
+//
+// This returns the last 4 bytes of the Subject Key Identifier if the
+// Certificate has one, or the PublicKeyId of the Certificate's Public Key if
+// it doesn't.
+func CertificateKeyId(cert *x509.Certificate) ([4]byte, error) {
+	if skid := cert.SubjectKeyId; len(skid) >= 4 {
+		return [4]byte{skid[len(skid)-4], skid[len(skid)-3], skid[len(skid)-2], skid[len(skid)-1]}, nil
+	}
+	return PublicKeyId(cert.PublicKey)
+}
+
+// Get the bytes of the subjectPublicKey BIT STRING from the PKIX encoding of
+// a public key.
+func subjectPublicKey(pubKey crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	spki := struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{}
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	}
+	return spki.PublicKey.Bytes, nil
+}
+
+const (
+	// Version 1 signatures are the legacy format written by early versions
+	// of evmctl. They have a different header layout, and are handled by
+	// SignatureV1 rather than Signature.
+	SignatureVersion1 = 0x01
+
+	// Version 2 signatures are made directly over the file digest.
+	SignatureVersion2 = 0x02
+
+	// Version 3 signatures are made over the hash of an ima_file_id
+	// structure, which binds the xattr type and hash algorithm into the
+	// signed data. See FileIdDigest.
+	SignatureVersion3 = 0x03
+)
+
 // IMA Signature encapsulation. This contains both the IMA Signature Header
 // directly, as well as the Signature, in bytes.
 type Signature struct {
@@ -67,11 +138,13 @@ type Signature struct {
 // reamining amount of data, or understand which key to find.
 type SignatureHeader struct {
 
-	// Always 0x03.
+	// Either 0x03 (XattrDigSig), 0x05 (XattrPortableDigSig) for portable
+	// EVM signatures, or 0x06 (XattrVerityDigSig) for signatures over an
+	// fs-verity digest.
 	Magic uint8
 
-	// Either format 0x01, or format 0x02. This library only supports IMA
-	// Version 0x02.
+	// Either format 0x01, 0x02 or 0x03. This struct supports IMA
+	// Version 0x02 and 0x03, see SignatureV1 for Version 0x01.
 	Version uint8
 
 	// IMA Hash Algorithm used. This is an awkwardly sorted enum of a mix
@@ -80,7 +153,7 @@ type SignatureHeader struct {
 	// well as the Hash() helper.
 	HashAlgorithm uint8
 
-	// Last 4 bytes of a SHA1 hash of the ASN.1 DER encoded RSA Public Key.
+	// Last 4 bytes of a SHA1 hash of the Public Key. See PublicKeyId.
 	// This is mostly useful to act as a bloom-filter for candidate keys to
 	// check the Signature against.
 	KeyID [4]byte
@@ -110,15 +183,27 @@ func (s SignatureHeader) String() string {
 // Get the native Go crypto.Hash used to compute the Hash that that signature
 // is over. This can be used to hash data to generate the data to verify
 // the signature against.
+//
+// Some IMA EVM Hash functions, such as SM3, have no native Go crypto.Hash, and
+// will return an error. Use Algorithm to handle those.
 func (h SignatureHeader) Hash() (*crypto.Hash, error) {
 	return HashFunctions.ToCrypto(h.HashAlgorithm)
 }
 
+// Get the IMA EVM Hash used to compute the Hash that the signature is over.
+// Unlike Hash, this works for all IMA EVM Hash functions, and Hash.New() can
+// be used to measure the data to verify the signature against.
+func (h SignatureHeader) Algorithm() (*Hash, error) {
+	return HashFunctions.Lookup(h.HashAlgorithm)
+}
+
 // Take a Signature, and convert it to a byte array. This can be used
 // to write out IMA EVM signatures.
 func Serialize(signature Signature) ([]byte, error) {
-	if signature.Header.Version != 0x02 {
-		return nil, fmt.Errorf("ima: version 2 signatures are supported, only")
+	switch signature.Header.Version {
+	case SignatureVersion2, SignatureVersion3:
+	default:
+		return nil, fmt.Errorf("ima: version 2 and 3 signatures are supported, only")
 	}
 	if signature.Signature == nil {
 		return nil, fmt.Errorf("ima: refusing to serialize without a signature")
@@ -152,7 +237,17 @@ func Parse(signature []byte) (*Signature, error) {
 	if err := binary.Read(data, binary.BigEndian, &line); err != nil {
 		return nil, err
 	}
-	if line.Magic != 0x03 {
+	if line.Version == SignatureVersion1 {
+		return nil, fmt.Errorf("ima: version 1 signatures must be parsed with ParseV1")
+	}
+
+	switch line.Magic {
+	case XattrDigSig, XattrPortableDigSig:
+	case XattrVerityDigSig:
+		if line.Version != SignatureVersion3 {
+			return nil, fmt.Errorf("ima: fs-verity signatures must be version 3")
+		}
+	default:
 		return nil, fmt.Errorf("ima: input data is in a bad format")
 	}
 
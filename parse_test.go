@@ -72,3 +72,25 @@ func TestRoundTrip(t *testing.T) {
 	assert(t, len(signature.Signature) == 128)
 	assert(t, len(signature.Signature) == int(signature.Header.SignatureLength))
 }
+
+func TestSerializeVersion(t *testing.T) {
+	sig := ima.Signature{
+		Header: ima.SignatureHeader{
+			Magic:         ima.XattrDigSig,
+			Version:       ima.SignatureVersion3,
+			HashAlgorithm: ima.SHA256.Id,
+		},
+		Signature: []byte{0x01, 0x02, 0x03},
+	}
+
+	buf, err := ima.Serialize(sig)
+	isok(t, err)
+	parsed, err := ima.Parse(buf)
+	isok(t, err)
+	assert(t, parsed.Header.Version == ima.SignatureVersion3)
+	assert(t, bytes.Compare(parsed.Signature, sig.Signature) == 0)
+
+	sig.Header.Version = 0x04
+	_, err = ima.Serialize(sig)
+	notok(t, err)
+}
@@ -0,0 +1,8 @@
+// A crypto.Signer backed by a private key in a PKCS#11 token, such as an
+// HSM, selected with an RFC 7512 pkcs11: URI. RSA and ECDSA keys are
+// supported, and the Signer can be passed to ima.Sign or xattr.Sign like any
+// other crypto.Signer.
+//
+// Talking to PKCS#11 modules requires cgo. Binaries built without cgo can
+// still parse URIs, but Open will always fail.
+package pkcs11
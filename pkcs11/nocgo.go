@@ -0,0 +1,49 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !cgo
+
+package pkcs11
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+)
+
+// Signer is a crypto.Signer for a private key in a PKCS#11 token. This
+// binary was built without cgo, so it can't be used.
+type Signer struct{}
+
+// Open always fails, since this binary was built without cgo.
+func Open(uri string) (*Signer, error) {
+	return nil, fmt.Errorf("pkcs11: built without cgo")
+}
+
+// OpenURI always fails, since this binary was built without cgo.
+func OpenURI(uri URI) (*Signer, error) {
+	return nil, fmt.Errorf("pkcs11: built without cgo")
+}
+
+func (s *Signer) Public() crypto.PublicKey {
+	return nil
+}
+
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return nil, fmt.Errorf("pkcs11: built without cgo")
+}
+
+func (s *Signer) Close() error {
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkcs11_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crypto"
+	"crypto/sha256"
+
+	"pault.ag/go/ima/pkcs11"
+)
+
+// None of this needs a token, so it runs even without SoftHSM, and without
+// cgo.
+func TestOpenErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ima-pkcs11")
+	isok(t, err)
+	defer os.RemoveAll(dir)
+	junk := filepath.Join(dir, "junk.so")
+	isok(t, ioutil.WriteFile(junk, []byte("not a shared library"), 0644))
+
+	for _, uri := range []string{
+		"pkcs11:token=ima;object=rsa?pin-value=1234",
+		"pkcs11:token=ima;object=rsa?module-path=" + filepath.Join(dir, "missing.so") + "&pin-value=1234",
+		"pkcs11:token=ima;object=rsa?module-path=" + junk + "&pin-value=1234",
+		"pkcs11:token=ima;object=rsa?module-path=" + junk + "&pin-source=" + filepath.Join(dir, "missing-pin"),
+		"https://example.com/",
+	} {
+		signer, err := pkcs11.Open(uri)
+		notok(t, err)
+		assert(t, signer == nil)
+	}
+}
+
+func TestClosedSigner(t *testing.T) {
+	signer := &pkcs11.Signer{}
+	isok(t, signer.Close())
+	isok(t, signer.Close())
+
+	digest := sha256.Sum256([]byte("totally legit elf af"))
+	_, err := signer.Sign(nil, digest[:], crypto.SHA256)
+	notok(t, err)
+}
@@ -0,0 +1,401 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build cgo
+
+package pkcs11
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"encoding/asn1"
+	"encoding/binary"
+
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+
+	p11 "github.com/miekg/pkcs11"
+)
+
+// Signer is a crypto.Signer for a private key in a PKCS#11 token.
+//
+// A Signer is safe for concurrent use, although signing operations are
+// done one at a time over a single session.
+type Signer struct {
+	lock    sync.Mutex
+	ctx     *p11.Ctx
+	session p11.SessionHandle
+	key     p11.ObjectHandle
+	keyType uint
+	public  crypto.PublicKey
+
+	// Set if this Signer initialized the module, and has to finalize it.
+	initialized bool
+}
+
+// Parse the PKCS#11 URI, and Open the private key it refers to.
+func Open(uri string) (*Signer, error) {
+	u, err := ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return OpenURI(*u)
+}
+
+// Load the PKCS#11 module, log in to the token, and find the private key
+// described by the URI, along with its public key. Exactly one private key
+// must match the URI.
+//
+// The Signer must be Closed when it's no longer needed.
+func OpenURI(uri URI) (*Signer, error) {
+	if uri.ModulePath == "" {
+		return nil, fmt.Errorf("pkcs11: URI has no module-path")
+	}
+	pin, err := uri.Pin()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := p11.New(uri.ModulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("pkcs11: can't load module %s", uri.ModulePath)
+	}
+	// If something else in this process already initialized the module,
+	// leave it to them to finalize it.
+	initialized := true
+	if err := ctx.Initialize(); err != nil {
+		if e, ok := err.(p11.Error); !ok || e != p11.CKR_CRYPTOKI_ALREADY_INITIALIZED {
+			ctx.Destroy()
+			return nil, err
+		}
+		initialized = false
+	}
+
+	signer := &Signer{ctx: ctx, initialized: initialized}
+	if err := signer.open(uri, pin); err != nil {
+		signer.Close()
+		return nil, err
+	}
+	return signer, nil
+}
+
+func (s *Signer) open(uri URI, pin string) error {
+	slot, err := findSlot(s.ctx, uri)
+	if err != nil {
+		return err
+	}
+	s.session, err = s.ctx.OpenSession(slot, p11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return err
+	}
+	if err := s.ctx.Login(s.session, p11.CKU_USER, pin); err != nil {
+		if e, ok := err.(p11.Error); !ok || e != p11.CKR_USER_ALREADY_LOGGED_IN {
+			return err
+		}
+	}
+
+	template := []*p11.Attribute{p11.NewAttribute(p11.CKA_CLASS, p11.CKO_PRIVATE_KEY)}
+	if uri.Object != "" {
+		template = append(template, p11.NewAttribute(p11.CKA_LABEL, uri.Object))
+	}
+	if len(uri.ID) != 0 {
+		template = append(template, p11.NewAttribute(p11.CKA_ID, uri.ID))
+	}
+	keys, err := s.findObjects(template)
+	if err != nil {
+		return err
+	}
+	if len(keys) != 1 {
+		return fmt.Errorf("pkcs11: URI matches %d private keys, not 1", len(keys))
+	}
+	s.key = keys[0]
+
+	attrs, err := s.ctx.GetAttributeValue(s.session, s.key, []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_KEY_TYPE, nil),
+		p11.NewAttribute(p11.CKA_ID, nil),
+	})
+	if err != nil {
+		return err
+	}
+	s.keyType = attributeUint(attrs[0].Value)
+	id := attrs[1].Value
+
+	// The public key may be a separate object with the same CKA_ID, and for
+	// RSA keys, the private key object usually has the public parts too.
+	pubTemplate := []*p11.Attribute{p11.NewAttribute(p11.CKA_CLASS, p11.CKO_PUBLIC_KEY)}
+	if len(id) != 0 {
+		pubTemplate = append(pubTemplate, p11.NewAttribute(p11.CKA_ID, id))
+	} else if uri.Object != "" {
+		pubTemplate = append(pubTemplate, p11.NewAttribute(p11.CKA_LABEL, uri.Object))
+	}
+	pubs, err := s.findObjects(pubTemplate)
+	if err != nil {
+		return err
+	}
+	candidates := append(pubs, s.key)
+
+	for _, object := range candidates {
+		switch s.keyType {
+		case p11.CKK_RSA:
+			s.public, err = s.rsaPublicKey(object)
+		case p11.CKK_EC:
+			s.public, err = s.ecdsaPublicKey(object)
+		default:
+			return fmt.Errorf("pkcs11: unsupported key type %d", s.keyType)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("pkcs11: can't find the public key: %s", err)
+}
+
+// Find the slot with a token that matches the URI. Exactly one must match.
+func findSlot(ctx *p11.Ctx, uri URI) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, err
+	}
+	matches := []uint{}
+	for _, slot := range slots {
+		if uri.SlotID != nil && *uri.SlotID != slot {
+			continue
+		}
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, err
+		}
+		if (uri.Token != "" && uri.Token != info.Label) ||
+			(uri.Manufacturer != "" && uri.Manufacturer != info.ManufacturerID) ||
+			(uri.Model != "" && uri.Model != info.Model) ||
+			(uri.Serial != "" && uri.Serial != info.SerialNumber) {
+			continue
+		}
+		matches = append(matches, slot)
+	}
+	if len(matches) != 1 {
+		return 0, fmt.Errorf("pkcs11: URI matches %d tokens, not 1", len(matches))
+	}
+	return matches[0], nil
+}
+
+func (s *Signer) findObjects(template []*p11.Attribute) ([]p11.ObjectHandle, error) {
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return nil, err
+	}
+	defer s.ctx.FindObjectsFinal(s.session)
+
+	ret := []p11.ObjectHandle{}
+	for {
+		objects, _, err := s.ctx.FindObjects(s.session, 16)
+		if err != nil {
+			return nil, err
+		}
+		if len(objects) == 0 {
+			return ret, nil
+		}
+		ret = append(ret, objects...)
+	}
+}
+
+func (s *Signer) rsaPublicKey(object p11.ObjectHandle) (*rsa.PublicKey, error) {
+	attrs, err := s.ctx.GetAttributeValue(s.session, object, []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_MODULUS, nil),
+		p11.NewAttribute(p11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	n := new(big.Int).SetBytes(attrs[0].Value)
+	e := new(big.Int).SetBytes(attrs[1].Value)
+	if n.Sign() == 0 || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("pkcs11: bad RSA public key")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+var (
+	oidP224 = asn1.ObjectIdentifier{1, 3, 132, 0, 33}
+	oidP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidP521 = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+)
+
+func (s *Signer) ecdsaPublicKey(object p11.ObjectHandle) (*ecdsa.PublicKey, error) {
+	attrs, err := s.ctx.GetAttributeValue(s.session, object, []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_EC_PARAMS, nil),
+		p11.NewAttribute(p11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(attrs[0].Value, &oid); err != nil {
+		return nil, fmt.Errorf("pkcs11: EC parameters are not a named curve")
+	}
+	var curve elliptic.Curve
+	switch {
+	case oid.Equal(oidP224):
+		curve = elliptic.P224()
+	case oid.Equal(oidP256):
+		curve = elliptic.P256()
+	case oid.Equal(oidP384):
+		curve = elliptic.P384()
+	case oid.Equal(oidP521):
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("pkcs11: unsupported curve %s", oid)
+	}
+
+	// CKA_EC_POINT is meant to be a DER OCTET STRING, but some modules
+	// return the bare point.
+	point := attrs[1].Value
+	var inner []byte
+	if rest, err := asn1.Unmarshal(point, &inner); err == nil && len(rest) == 0 {
+		point = inner
+	}
+	x, y := elliptic.Unmarshal(curve, point)
+	if x == nil {
+		return nil, fmt.Errorf("pkcs11: bad EC point")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// CK_ULONG attributes are returned in native byte order.
+func attributeUint(value []byte) uint {
+	switch len(value) {
+	case 4:
+		return uint(binary.NativeEndian.Uint32(value))
+	case 8:
+		return uint(binary.NativeEndian.Uint64(value))
+	default:
+		return ^uint(0)
+	}
+}
+
+// Return the public key of the private key in the token.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+var digestOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.MD5:      {1, 2, 840, 113549, 2, 5},
+	crypto.SHA1:     {1, 3, 14, 3, 2, 26},
+	crypto.SHA224:   {2, 16, 840, 1, 101, 3, 4, 2, 4},
+	crypto.SHA256:   {2, 16, 840, 1, 101, 3, 4, 2, 1},
+	crypto.SHA384:   {2, 16, 840, 1, 101, 3, 4, 2, 2},
+	crypto.SHA512:   {2, 16, 840, 1, 101, 3, 4, 2, 3},
+	crypto.SHA3_256: {2, 16, 840, 1, 101, 3, 4, 2, 8},
+	crypto.SHA3_384: {2, 16, 840, 1, 101, 3, 4, 2, 9},
+	crypto.SHA3_512: {2, 16, 840, 1, 101, 3, 4, 2, 10},
+}
+
+// Encode the digest as a PKCS#1 DigestInfo, which CKM_RSA_PKCS expects the
+// caller to have done.
+func digestInfo(hash crypto.Hash, digest []byte) ([]byte, error) {
+	if hash == 0 {
+		return digest, nil
+	}
+	oid, ok := digestOIDs[hash]
+	if !ok {
+		return nil, fmt.Errorf("pkcs11: unsupported hash %s for RSA", hash)
+	}
+	if len(digest) != hash.Size() {
+		return nil, fmt.Errorf("pkcs11: digest is the wrong size for %s", hash)
+	}
+	return asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Digest    []byte
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue},
+		Digest:    digest,
+	})
+}
+
+// Sign the digest with the private key in the token. RSA keys make PKCS#1
+// v1.5 signatures, and ECDSA keys make ASN.1 DER encoded signatures, just
+// like the crypto/rsa and crypto/ecdsa Signers. The rand argument is not
+// used, since the token has its own entropy source.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mechanism uint
+	data := digest
+
+	switch s.keyType {
+	case p11.CKK_RSA:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, fmt.Errorf("pkcs11: RSA-PSS is not supported")
+		}
+		var err error
+		if data, err = digestInfo(opts.HashFunc(), digest); err != nil {
+			return nil, err
+		}
+		mechanism = p11.CKM_RSA_PKCS
+	case p11.CKK_EC:
+		mechanism = p11.CKM_ECDSA
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ctx == nil {
+		return nil, fmt.Errorf("pkcs11: signer is closed")
+	}
+	if err := s.ctx.SignInit(s.session, []*p11.Mechanism{p11.NewMechanism(mechanism, nil)}, s.key); err != nil {
+		return nil, err
+	}
+	signature, err := s.ctx.Sign(s.session, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.keyType == p11.CKK_EC {
+		// CKM_ECDSA returns r || s, rather than the ASN.1 crypto/ecdsa uses.
+		if len(signature)%2 != 0 {
+			return nil, fmt.Errorf("pkcs11: bad ECDSA signature length")
+		}
+		half := len(signature) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(signature[:half]),
+			S: new(big.Int).SetBytes(signature[half:]),
+		})
+	}
+	return signature, nil
+}
+
+// Log out of the token, and unload the PKCS#11 module. Closing a Signer
+// more than once is harmless, but it can't sign once it's been Closed.
+func (s *Signer) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ctx == nil {
+		return nil
+	}
+	if s.session != 0 {
+		s.ctx.Logout(s.session)
+		s.ctx.CloseSession(s.session)
+	}
+	var err error
+	if s.initialized {
+		err = s.ctx.Finalize()
+	}
+	s.ctx.Destroy()
+	s.ctx = nil
+	return err
+}
@@ -0,0 +1,188 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build cgo
+
+package pkcs11_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"encoding/asn1"
+
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+
+	p11 "github.com/miekg/pkcs11"
+
+	"pault.ag/go/ima"
+	"pault.ag/go/ima/pkcs11"
+)
+
+// Find the SoftHSMv2 module, or skip the test.
+func softHSM(t *testing.T) string {
+	paths := []string{
+		os.Getenv("SOFTHSM2_MODULE"),
+		"/usr/lib/softhsm/libsofthsm2.so",
+		"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+		"/usr/lib64/pkcs11/libsofthsm2.so",
+		"/usr/local/lib/softhsm/libsofthsm2.so",
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	t.Skip("SoftHSMv2 is not installed")
+	return ""
+}
+
+// Create a new SoftHSM token labeled "ima" with the user PIN "1234",
+// holding an RSA key labeled "rsa" and a P-256 key labeled "ecdsa".
+func setupToken(t *testing.T, module string) func() {
+	dir, err := ioutil.TempDir("", "ima-softhsm")
+	isok(t, err)
+	isok(t, os.Mkdir(filepath.Join(dir, "tokens"), 0700))
+	conf := filepath.Join(dir, "softhsm2.conf")
+	isok(t, ioutil.WriteFile(conf, []byte(fmt.Sprintf(
+		"directories.tokendir = %s\nobjectstore.backend = file\n",
+		filepath.Join(dir, "tokens"),
+	)), 0600))
+	oldConf, hadConf := os.LookupEnv("SOFTHSM2_CONF")
+	isok(t, os.Setenv("SOFTHSM2_CONF", conf))
+	cleanup := func() {
+		if hadConf {
+			os.Setenv("SOFTHSM2_CONF", oldConf)
+		} else {
+			os.Unsetenv("SOFTHSM2_CONF")
+		}
+		os.RemoveAll(dir)
+	}
+
+	ctx := p11.New(module)
+	assert(t, ctx != nil)
+	defer ctx.Destroy()
+	isok(t, ctx.Initialize())
+	defer ctx.Finalize()
+
+	slots, err := ctx.GetSlotList(false)
+	isok(t, err)
+	assert(t, len(slots) != 0)
+	isok(t, ctx.InitToken(slots[0], "1234", "ima"))
+
+	slots, err = ctx.GetSlotList(true)
+	isok(t, err)
+	var slot uint
+	for _, s := range slots {
+		info, err := ctx.GetTokenInfo(s)
+		isok(t, err)
+		if info.Label == "ima" {
+			slot = s
+		}
+	}
+
+	session, err := ctx.OpenSession(slot, p11.CKF_SERIAL_SESSION|p11.CKF_RW_SESSION)
+	isok(t, err)
+	defer ctx.CloseSession(session)
+	isok(t, ctx.Login(session, p11.CKU_SO, "1234"))
+	isok(t, ctx.InitPIN(session, "1234"))
+	isok(t, ctx.Logout(session))
+	isok(t, ctx.Login(session, p11.CKU_USER, "1234"))
+	defer ctx.Logout(session)
+
+	private := func(label string, id byte) []*p11.Attribute {
+		return []*p11.Attribute{
+			p11.NewAttribute(p11.CKA_TOKEN, true),
+			p11.NewAttribute(p11.CKA_PRIVATE, true),
+			p11.NewAttribute(p11.CKA_SIGN, true),
+			p11.NewAttribute(p11.CKA_LABEL, label),
+			p11.NewAttribute(p11.CKA_ID, []byte{id}),
+		}
+	}
+
+	_, _, err = ctx.GenerateKeyPair(session,
+		[]*p11.Mechanism{p11.NewMechanism(p11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)},
+		[]*p11.Attribute{
+			p11.NewAttribute(p11.CKA_TOKEN, true),
+			p11.NewAttribute(p11.CKA_VERIFY, true),
+			p11.NewAttribute(p11.CKA_MODULUS_BITS, 2048),
+			p11.NewAttribute(p11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+			p11.NewAttribute(p11.CKA_LABEL, "rsa"),
+			p11.NewAttribute(p11.CKA_ID, []byte{1}),
+		},
+		private("rsa", 1),
+	)
+	isok(t, err)
+
+	p256, err := asn1.Marshal(asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7})
+	isok(t, err)
+	_, _, err = ctx.GenerateKeyPair(session,
+		[]*p11.Mechanism{p11.NewMechanism(p11.CKM_EC_KEY_PAIR_GEN, nil)},
+		[]*p11.Attribute{
+			p11.NewAttribute(p11.CKA_TOKEN, true),
+			p11.NewAttribute(p11.CKA_VERIFY, true),
+			p11.NewAttribute(p11.CKA_EC_PARAMS, p256),
+			p11.NewAttribute(p11.CKA_LABEL, "ecdsa"),
+			p11.NewAttribute(p11.CKA_ID, []byte{2}),
+		},
+		private("ecdsa", 2),
+	)
+	isok(t, err)
+
+	return cleanup
+}
+
+func TestSignerSoftHSM(t *testing.T) {
+	module := softHSM(t)
+	cleanup := setupToken(t, module)
+	defer cleanup()
+
+	digest := sha256.Sum256([]byte("totally legit elf af"))
+
+	for _, object := range []string{"rsa", "ecdsa"} {
+		signer, err := pkcs11.Open(fmt.Sprintf(
+			"pkcs11:token=ima;object=%s;type=private?module-path=%s&pin-value=1234",
+			object, module,
+		))
+		isok(t, err)
+
+		sigBytes, err := ima.Sign(signer, rand.Reader, digest[:], crypto.SHA256)
+		isok(t, err)
+		sig, err := ima.Parse(sigBytes)
+		isok(t, err)
+
+		keys := ima.NewKeyPool()
+		isok(t, keys.AddKey(signer.Public()))
+		_, err = sig.Verify(ima.VerifyOptions{
+			Digest: digest[:],
+			Hash:   crypto.SHA256,
+			Keys:   keys,
+		})
+		isok(t, err)
+		isok(t, signer.Close())
+	}
+
+	_, err := pkcs11.Open(fmt.Sprintf("pkcs11:token=ima?module-path=%s&pin-value=1234", module))
+	notok(t, err)
+	_, err = pkcs11.Open(fmt.Sprintf("pkcs11:token=ima;object=rsa?module-path=%s&pin-value=4321", module))
+	notok(t, err)
+}
@@ -0,0 +1,155 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkcs11
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Parsed RFC 7512 PKCS#11 URI, such as:
+//
+//	pkcs11:token=ima;object=release;type=private?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=/etc/ima/pin
+//
+// Only the attributes needed to find a private key are understood.
+type URI struct {
+	// Token attributes, used to pick the slot.
+	Token        string
+	Manufacturer string
+	Model        string
+	Serial       string
+	SlotID       *uint
+
+	// Object attributes, used to pick the key.
+	Object string
+	ID     []byte
+	Type   string
+
+	// Path to the PKCS#11 module to load.
+	ModulePath string
+
+	// User PIN, given directly, or the path of a file to read it from.
+	PinValue  string
+	PinSource string
+}
+
+// Check to see if a string looks like a PKCS#11 URI, rather than a path.
+func IsURI(uri string) bool {
+	return strings.HasPrefix(uri, "pkcs11:")
+}
+
+// Parse a PKCS#11 URI. Unknown path attributes are an error, since
+// ignoring them could select the wrong key; unknown query attributes are
+// ignored, as RFC 7512 allows.
+func ParseURI(uri string) (*URI, error) {
+	if !IsURI(uri) {
+		return nil, fmt.Errorf("pkcs11: %q is not a pkcs11: URI", uri)
+	}
+	uri = strings.TrimPrefix(uri, "pkcs11:")
+
+	path, query := uri, ""
+	if i := strings.Index(uri, "?"); i >= 0 {
+		path, query = uri[:i], uri[i+1:]
+	}
+
+	ret := URI{}
+	attrs, err := parseAttributes(path, ";")
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range attrs {
+		switch key {
+		case "token":
+			ret.Token = value
+		case "manufacturer":
+			ret.Manufacturer = value
+		case "model":
+			ret.Model = value
+		case "serial":
+			ret.Serial = value
+		case "slot-id":
+			id, err := strconv.ParseUint(value, 10, 0)
+			if err != nil {
+				return nil, fmt.Errorf("pkcs11: bad slot-id: %s", err)
+			}
+			slot := uint(id)
+			ret.SlotID = &slot
+		case "object":
+			ret.Object = value
+		case "id":
+			ret.ID = []byte(value)
+		case "type":
+			ret.Type = value
+		default:
+			return nil, fmt.Errorf("pkcs11: unsupported URI attribute %q", key)
+		}
+	}
+
+	attrs, err = parseAttributes(query, "&")
+	if err != nil {
+		return nil, err
+	}
+	ret.ModulePath = attrs["module-path"]
+	ret.PinValue = attrs["pin-value"]
+	ret.PinSource = attrs["pin-source"]
+
+	if ret.Type != "" && ret.Type != "private" {
+		return nil, fmt.Errorf("pkcs11: URI must refer to a private key, not %q", ret.Type)
+	}
+	return &ret, nil
+}
+
+func parseAttributes(data, sep string) (map[string]string, error) {
+	ret := map[string]string{}
+	if data == "" {
+		return ret, nil
+	}
+	for _, attr := range strings.Split(data, sep) {
+		parts := strings.SplitN(attr, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("pkcs11: bad URI attribute %q", attr)
+		}
+		value, err := url.PathUnescape(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("pkcs11: bad URI attribute %q: %s", attr, err)
+		}
+		if _, ok := ret[parts[0]]; ok {
+			return nil, fmt.Errorf("pkcs11: duplicate URI attribute %q", parts[0])
+		}
+		ret[parts[0]] = value
+	}
+	return ret, nil
+}
+
+// Get the user PIN, either from the pin-value, or by reading the file named
+// by pin-source, without any trailing newline. If neither is set, the PIN
+// is empty, which works for tokens with a protected authentication path.
+func (u URI) Pin() (string, error) {
+	if u.PinValue != "" {
+		return u.PinValue, nil
+	}
+	if u.PinSource == "" {
+		return "", nil
+	}
+	path := strings.TrimPrefix(u.PinSource, "file:")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkcs11_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"pault.ag/go/ima/pkcs11"
+)
+
+func TestParseURI(t *testing.T) {
+	uri, err := pkcs11.ParseURI("pkcs11:token=My%20Token;object=release;id=%01%02;slot-id=3;type=private" +
+		"?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234&unknown=ok")
+	isok(t, err)
+	assert(t, uri.Token == "My Token")
+	assert(t, uri.Object == "release")
+	assert(t, bytes.Equal(uri.ID, []byte{1, 2}))
+	assert(t, uri.SlotID != nil && *uri.SlotID == 3)
+	assert(t, uri.ModulePath == "/usr/lib/softhsm/libsofthsm2.so")
+
+	pin, err := uri.Pin()
+	isok(t, err)
+	assert(t, pin == "1234")
+
+	for _, bad := range []string{
+		"/etc/keys/privkey_evm.pem",
+		"pkcs11:token",
+		"pkcs11:bogus=1",
+		"pkcs11:token=a;token=b",
+		"pkcs11:type=cert",
+		"pkcs11:object=%zz",
+		"pkcs11:slot-id=x",
+	} {
+		_, err := pkcs11.ParseURI(bad)
+		notok(t, err)
+	}
+	assert(t, pkcs11.IsURI("pkcs11:object=a"))
+	assert(t, !pkcs11.IsURI("/etc/keys/privkey_evm.pem"))
+}
+
+func TestURIPinSource(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "ima-pin")
+	isok(t, err)
+	defer os.Remove(tmpfile.Name())
+	_, err = tmpfile.Write([]byte("s3cret\n"))
+	isok(t, err)
+	isok(t, tmpfile.Close())
+
+	uri, err := pkcs11.ParseURI("pkcs11:object=a?pin-source=file:" + tmpfile.Name())
+	isok(t, err)
+	pin, err := uri.Pin()
+	isok(t, err)
+	assert(t, pin == "s3cret")
+
+	uri, err = pkcs11.ParseURI("pkcs11:object=a?pin-source=/nonexistent")
+	isok(t, err)
+	_, err = uri.Pin()
+	notok(t, err)
+}
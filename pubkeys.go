@@ -16,19 +16,80 @@ package ima
 
 import (
 	"crypto"
+	"crypto/x509"
 	"fmt"
+	"sync"
 )
 
 // Initialize a KeyPool
 func NewKeyPool() KeyPool {
-	return KeyPool{pool: map[string][]crypto.PublicKey{}}
+	return KeyPool{pool: newKeyPool()}
+}
+
+// Initialize a KeyPool that only admits Certificates issued by one of the
+// TrustAnchors, which is how the kernel's .ima keyring behaves. Bare public
+// keys can't be checked, so AddKey will always fail.
+func NewTrustedKeyPool(anchors *TrustAnchors) KeyPool {
+	pool := newKeyPool()
+	pool.anchors = anchors
+	return KeyPool{pool: pool}
 }
 
 // KeyPool is a keyring of crypto.PublicKeys. Internally, this uses the
 // PublicKeyId to serve not unlike a bloom filter for key selection, which
 // allows the Verify function to only try keys which have matching Key IDs.
+//
+// A KeyPool is safe for concurrent use. Copies of a KeyPool share the same
+// underlying keyring, so keys added through one copy are visible through the
+// others; use Clone to get an independent KeyPool.
 type KeyPool struct {
-	pool map[string][]crypto.PublicKey
+	pool *keyPool
+}
+
+// Key in the KeyPool, along with the Key IDs it's indexed under.
+type keyEntry struct {
+	key   crypto.PublicKey
+	cert  *x509.Certificate
+	id    [4]byte
+	idV1  [8]byte
+	hasV1 bool
+}
+
+type keyPool struct {
+	lock    sync.RWMutex
+	entries []keyEntry
+	ids     map[[4]byte][]keyEntry
+	idsV1   map[[8]byte][]keyEntry
+
+	// If set, only Certificates issued by these are admitted.
+	anchors *TrustAnchors
+}
+
+func newKeyPool() *keyPool {
+	return &keyPool{
+		ids:   map[[4]byte][]keyEntry{},
+		idsV1: map[[8]byte][]keyEntry{},
+	}
+}
+
+// Add an entry to the indexes. The lock must be held for writing.
+func (p *keyPool) index(entry keyEntry) {
+	p.ids[entry.id] = append(p.ids[entry.id], entry)
+	if entry.hasV1 {
+		p.idsV1[entry.idV1] = append(p.idsV1[entry.idV1], entry)
+	}
+}
+
+// Create a new keyPool with a copy of the entries. The lock must be held
+// for reading.
+func (p *keyPool) clone() *keyPool {
+	ret := newKeyPool()
+	ret.anchors = p.anchors
+	ret.entries = append([]keyEntry{}, p.entries...)
+	for _, entry := range ret.entries {
+		ret.index(entry)
+	}
+	return ret
 }
 
 // Cheeck to see if the crypto.PublicKey's PublicKeyId is set in the underlying
@@ -38,27 +99,259 @@ func (k KeyPool) MaybeContains(key crypto.PublicKey) bool {
 	if err != nil {
 		return false
 	}
-	idk := fmt.Sprintf("%x", id)
-	_, ok := k.pool[idk]
-	return ok
+	return len(k.Get(id)) != 0
 }
 
 // Get all matching keys by the KeyId.
 func (k KeyPool) Get(id [4]byte) []crypto.PublicKey {
-	idk := fmt.Sprintf("%x", id)
-	return k.pool[idk]
+	return candidateKeys(k.getEntries(id))
+}
+
+// Get all matching keys by the version 1 KeyId. See PublicKeyIdV1.
+func (k KeyPool) GetV1(id [8]byte) []crypto.PublicKey {
+	return candidateKeys(k.getEntriesV1(id))
+}
+
+// Get all matching keys by the KeyId, along with their Certificates. This
+// implements KeyResolver, and never returns an error.
+func (k KeyPool) Resolve(id [4]byte) ([]Candidate, error) {
+	return k.getEntries(id), nil
+}
+
+// Get all matching keys by the version 1 KeyId, along with their
+// Certificates. This implements KeyResolverV1, and never returns an error.
+func (k KeyPool) ResolveV1(id [8]byte) ([]Candidate, error) {
+	return k.getEntriesV1(id), nil
+}
+
+func (k KeyPool) getEntries(id [4]byte) []Candidate {
+	if k.pool == nil {
+		return nil
+	}
+	k.pool.lock.RLock()
+	defer k.pool.lock.RUnlock()
+	return entryCandidates(k.pool.ids[id])
+}
+
+func (k KeyPool) getEntriesV1(id [8]byte) []Candidate {
+	if k.pool == nil {
+		return nil
+	}
+	k.pool.lock.RLock()
+	defer k.pool.lock.RUnlock()
+	return entryCandidates(k.pool.idsV1[id])
+}
+
+func entryCandidates(entries []keyEntry) []Candidate {
+	ret := make([]Candidate, len(entries))
+	for i, entry := range entries {
+		ret[i] = Candidate{Key: entry.key, Certificate: entry.cert}
+	}
+	return ret
+}
+
+func candidateKeys(candidates []Candidate) []crypto.PublicKey {
+	ret := make([]crypto.PublicKey, len(candidates))
+	for i, candidate := range candidates {
+		ret[i] = candidate.Key
+	}
+	return ret
 }
 
 // Add a new crypto.PublicKey to the keychain.
+//
+// RSA keys will also be indexed by their version 1 KeyId, so that legacy
+// signatures can be checked against the same KeyPool.
+//
+// KeyPools created with NewTrustedKeyPool will refuse bare keys.
 func (k KeyPool) AddKey(key crypto.PublicKey) error {
-	id, err := PublicKeyId(key)
+	entry, err := k.keyEntry(key)
 	if err != nil {
 		return err
 	}
-	idk := fmt.Sprintf("%x", id)
-	if _, ok := k.pool[idk]; !ok {
-		k.pool[idk] = []crypto.PublicKey{}
+	k.add(entry)
+	return nil
+}
+
+// Add the Public Key of a Certificate to the keychain. The key will be indexed
+// by the CertificateKeyId, which is how the kernel matches keys, rather than
+// by the PublicKeyId.
+//
+// KeyPools created with NewTrustedKeyPool will only accept the Certificate
+// if it passes TrustAnchors.Check.
+func (k KeyPool) AddCertificate(cert *x509.Certificate) error {
+	entry, err := k.certificateEntry(cert)
+	if err != nil {
+		return err
 	}
-	k.pool[idk] = append(k.pool[idk], key)
+	k.add(entry)
 	return nil
 }
+
+// Check a bare key can be added, and work out its Key IDs.
+func (k KeyPool) keyEntry(key crypto.PublicKey) (keyEntry, error) {
+	if k.pool.anchors != nil {
+		return keyEntry{}, fmt.Errorf("%w: bare public keys can't be checked against trust anchors", UntrustedKey)
+	}
+	id, err := PublicKeyId(key)
+	if err != nil {
+		return keyEntry{}, err
+	}
+	return newKeyEntry(key, nil, id), nil
+}
+
+// Check a Certificate can be added, and work out its Key IDs.
+func (k KeyPool) certificateEntry(cert *x509.Certificate) (keyEntry, error) {
+	if cert.PublicKey == nil {
+		return keyEntry{}, fmt.Errorf("ima: certificate public key format not supported")
+	}
+	if k.pool.anchors != nil {
+		if err := k.pool.anchors.Check(cert); err != nil {
+			return keyEntry{}, err
+		}
+	}
+	id, err := CertificateKeyId(cert)
+	if err != nil {
+		return keyEntry{}, err
+	}
+	return newKeyEntry(cert.PublicKey, cert, id), nil
+}
+
+func newKeyEntry(key crypto.PublicKey, cert *x509.Certificate, id [4]byte) keyEntry {
+	entry := keyEntry{key: key, cert: cert, id: id}
+	if idV1, err := PublicKeyIdV1(key); err == nil {
+		entry.idV1 = idV1
+		entry.hasV1 = true
+	}
+	return entry
+}
+
+// Add the entries to the keychain all at once, so nobody sees only some of
+// them.
+func (k KeyPool) add(entries ...keyEntry) {
+	k.pool.lock.Lock()
+	defer k.pool.lock.Unlock()
+	for _, entry := range entries {
+		k.pool.entries = append(k.pool.entries, entry)
+		k.pool.index(entry)
+	}
+}
+
+// Remove a crypto.PublicKey from the keychain, including every copy of it
+// that was added, and return true if it was found. Keys are compared with
+// their Equal method, if they have one.
+func (k KeyPool) RemoveKey(key crypto.PublicKey) bool {
+	if k.pool == nil {
+		return false
+	}
+	k.pool.lock.Lock()
+	defer k.pool.lock.Unlock()
+
+	entries := []keyEntry{}
+	for _, entry := range k.pool.entries {
+		if !keysEqual(entry.key, key) {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == len(k.pool.entries) {
+		return false
+	}
+
+	pool := newKeyPool()
+	pool.entries = entries
+	for _, entry := range entries {
+		pool.index(entry)
+	}
+	k.pool.entries, k.pool.ids, k.pool.idsV1 = pool.entries, pool.ids, pool.idsV1
+	return true
+}
+
+func keysEqual(a, b crypto.PublicKey) bool {
+	if equal, ok := a.(interface{ Equal(crypto.PublicKey) bool }); ok {
+		return equal.Equal(b)
+	}
+	return a == b
+}
+
+// Return all the keys in the keychain, in the order they were added.
+func (k KeyPool) Keys() []crypto.PublicKey {
+	ret := []crypto.PublicKey{}
+	k.Range(func(key crypto.PublicKey) bool {
+		ret = append(ret, key)
+		return true
+	})
+	return ret
+}
+
+// Return the Certificates of all the keys that were added with one, in the
+// order they were added.
+func (k KeyPool) Certificates() []*x509.Certificate {
+	if k.pool == nil {
+		return nil
+	}
+	k.pool.lock.RLock()
+	defer k.pool.lock.RUnlock()
+
+	ret := []*x509.Certificate{}
+	for _, entry := range k.pool.entries {
+		if entry.cert != nil {
+			ret = append(ret, entry.cert)
+		}
+	}
+	return ret
+}
+
+// Call f for each key in the keychain, in the order they were added, until f
+// returns false. This iterates over a snapshot of the keychain, so f is free
+// to modify the KeyPool.
+func (k KeyPool) Range(f func(key crypto.PublicKey) bool) {
+	if k.pool == nil {
+		return
+	}
+	k.pool.lock.RLock()
+	entries := append([]keyEntry{}, k.pool.entries...)
+	k.pool.lock.RUnlock()
+
+	for _, entry := range entries {
+		if !f(entry.key) {
+			return
+		}
+	}
+}
+
+// Return the number of keys in the keychain.
+func (k KeyPool) Len() int {
+	if k.pool == nil {
+		return 0
+	}
+	k.pool.lock.RLock()
+	defer k.pool.lock.RUnlock()
+	return len(k.pool.entries)
+}
+
+// Return a snapshot of the KeyPool, which can be changed independently of
+// this one.
+func (k KeyPool) Clone() KeyPool {
+	if k.pool == nil {
+		return NewKeyPool()
+	}
+	k.pool.lock.RLock()
+	defer k.pool.lock.RUnlock()
+	return KeyPool{pool: k.pool.clone()}
+}
+
+// Atomically replace all of the keys in this KeyPool with a snapshot of the
+// keys in other. The keys aren't checked against this KeyPool's
+// TrustAnchors, if any. Any Verify call running at the same time will either see
+// all of the old keys, or all of the new ones.
+//
+// This is handy to reload keys from disk while other goroutines are using the
+// KeyPool.
+func (k KeyPool) Replace(other KeyPool) {
+	snapshot := other.Clone()
+	k.pool.lock.Lock()
+	defer k.pool.lock.Unlock()
+	k.pool.entries = snapshot.pool.entries
+	k.pool.ids = snapshot.pool.ids
+	k.pool.idsV1 = snapshot.pool.idsV1
+}
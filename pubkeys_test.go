@@ -15,10 +15,21 @@
 package ima_test
 
 import (
+	"bytes"
+	"sync"
 	"testing"
 
+	"encoding/asn1"
+
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
 
 	"pault.ag/go/ima"
 )
@@ -39,3 +50,208 @@ func TestKeyPool(t *testing.T) {
 	assert(t, pool.MaybeContains(key.Public()) == true)
 	assert(t, len(pool.Get(id)) == 1)
 }
+
+func TestPublicKeyIdSubjectPublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	isok(t, err)
+
+	for _, key := range []crypto.Signer{rsaKey, ecdsaKey} {
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		isok(t, err)
+
+		// SHA1 over the subjectPublicKey, which is the RFC 5280 method of
+		// computing a Subject Key Identifier.
+		spki := struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}{}
+		_, err = asn1.Unmarshal(der, &spki)
+		isok(t, err)
+		skid := sha1.Sum(spki.PublicKey.Bytes)
+
+		id, err := ima.PublicKeyId(key.Public())
+		isok(t, err)
+		assert(t, bytes.Compare(id[:], skid[16:]) == 0)
+	}
+}
+
+func TestAddCertificate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+
+	// A CA that doesn't use SHA1 to generate Subject Key Identifiers.
+	cert := newTestKeyCert(t, nil, key, x509.Certificate{
+		SubjectKeyId: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+	}).cert
+
+	id, err := ima.CertificateKeyId(cert)
+	isok(t, err)
+	assert(t, bytes.Compare(id[:], []byte{0x05, 0x06, 0x07, 0x08}) == 0)
+
+	digest := sha256.Sum256([]byte("Totally real ELF no tricks"))
+	sigBytes, err := ima.Sign(key, rand.Reader, digest[:], ima.SignatureOptions{
+		Hash:        ima.SHA256,
+		Certificate: cert,
+	})
+	isok(t, err)
+	sig, err := ima.Parse(sigBytes)
+	isok(t, err)
+	assert(t, sig.Header.KeyID == id)
+
+	opts := ima.VerifyOptions{Digest: digest[:], Hash: crypto.SHA256}
+
+	keys := ima.NewKeyPool()
+	isok(t, keys.AddKey(key.Public()))
+	opts.Keys = keys
+	_, err = sig.Verify(opts)
+	assert(t, err == ima.UnknownSigner)
+
+	certs := ima.NewKeyPool()
+	isok(t, certs.AddCertificate(cert))
+	opts.Keys = certs
+	_, err = sig.Verify(opts)
+	isok(t, err)
+}
+
+func TestCertificateKeyIdFallback(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	isok(t, err)
+
+	// Leaf certificates don't get a Subject Key Identifier by default.
+	cert := newTestKeyCert(t, nil, key, x509.Certificate{}).cert
+	assert(t, len(cert.SubjectKeyId) == 0)
+
+	certId, err := ima.CertificateKeyId(cert)
+	isok(t, err)
+	keyId, err := ima.PublicKeyId(key.Public())
+	isok(t, err)
+	assert(t, certId == keyId)
+}
+
+func TestKeyPoolRemove(t *testing.T) {
+	pool := ima.NewKeyPool()
+	keys := []crypto.PublicKey{}
+	for i := 0; i < 3; i++ {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		isok(t, err)
+		isok(t, pool.AddKey(key.Public()))
+		keys = append(keys, key.Public())
+	}
+	assert(t, pool.Len() == 3)
+
+	// A copy of the key should match, not just the same pointer.
+	pub := *keys[1].(*ecdsa.PublicKey)
+	assert(t, pool.RemoveKey(&pub))
+	assert(t, !pool.RemoveKey(&pub))
+
+	assert(t, pool.Len() == 2)
+	assert(t, pool.MaybeContains(keys[0]))
+	assert(t, !pool.MaybeContains(keys[1]))
+	assert(t, pool.MaybeContains(keys[2]))
+
+	remaining := pool.Keys()
+	assert(t, len(remaining) == 2)
+	assert(t, remaining[0] == keys[0])
+	assert(t, remaining[1] == keys[2])
+}
+
+func TestKeyPoolRange(t *testing.T) {
+	pool := ima.NewKeyPool()
+	for i := 0; i < 3; i++ {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		isok(t, err)
+		isok(t, pool.AddKey(key.Public()))
+	}
+
+	seen := 0
+	pool.Range(func(key crypto.PublicKey) bool {
+		seen++
+		// Changing the pool while iterating is allowed.
+		pool.RemoveKey(key)
+		return seen < 2
+	})
+	assert(t, seen == 2)
+	assert(t, pool.Len() == 1)
+}
+
+func TestKeyPoolCloneReplace(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	isok(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	isok(t, err)
+
+	pool := ima.NewKeyPool()
+	isok(t, pool.AddKey(key.Public()))
+
+	clone := pool.Clone()
+	isok(t, clone.AddKey(other.Public()))
+	assert(t, pool.Len() == 1)
+	assert(t, clone.Len() == 2)
+
+	replacement := ima.NewKeyPool()
+	isok(t, replacement.AddKey(other.Public()))
+
+	// Copies share the same keyring, so they see the replacement too.
+	shared := pool
+	pool.Replace(replacement)
+	assert(t, shared.Len() == 1)
+	assert(t, !shared.MaybeContains(key.Public()))
+	assert(t, shared.MaybeContains(other.Public()))
+
+	// And the replacement was copied, not shared.
+	isok(t, replacement.AddKey(key.Public()))
+	assert(t, pool.Len() == 1)
+}
+
+func TestKeyPoolConcurrent(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	isok(t, err)
+	digest := sha256.Sum256([]byte("Totally real ELF no tricks"))
+	sigBytes, err := ima.Sign(key, rand.Reader, digest[:], crypto.SHA256)
+	isok(t, err)
+	sig, err := ima.Parse(sigBytes)
+	isok(t, err)
+
+	pool := ima.NewKeyPool()
+	isok(t, pool.AddKey(key.Public()))
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				// The key is always in the pool, either the original, or
+				// the replacement.
+				if _, err := sig.Verify(ima.VerifyOptions{
+					Keys:   pool,
+					Digest: digest[:],
+					Hash:   crypto.SHA256,
+				}); err != nil {
+					errs <- err
+					return
+				}
+				pool.Len()
+				pool.Keys()
+			}
+		}()
+	}
+
+	for j := 0; j < 50; j++ {
+		replacement := ima.NewKeyPool()
+		isok(t, replacement.AddKey(key.Public()))
+		pool.Replace(replacement)
+
+		isok(t, pool.AddKey(key.Public()))
+		pool.RemoveKey(&rsa.PublicKey{})
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		isok(t, err)
+	}
+}
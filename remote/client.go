@@ -0,0 +1,139 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"encoding/json"
+	"encoding/pem"
+
+	"crypto"
+	"crypto/rsa"
+)
+
+// Responses larger than this are refused.
+const maxResponseSize = 64 << 10
+
+// Signer is a crypto.Signer that signs digests with a key on a Server.
+type Signer struct {
+	// Base URL of the Server.
+	URL string
+
+	// Bearer token to authenticate with.
+	Token string
+
+	// Name of the key to sign with, or empty for the client's default key.
+	Key string
+
+	// HTTP Client to make requests with.
+	Client *http.Client
+
+	public crypto.PublicKey
+}
+
+// Create a new Signer for a key on the Server at the base URL, using the
+// http.DefaultClient, and fetch the key's public key.
+func NewSigner(baseURL, token, key string) (*Signer, error) {
+	signer := &Signer{
+		URL:    strings.TrimSuffix(baseURL, "/"),
+		Token:  token,
+		Key:    key,
+		Client: http.DefaultClient,
+	}
+	if err := signer.fetchPublic(); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+func (s *Signer) do(req *http.Request) ([]byte, error) {
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remote: server returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+func (s *Signer) fetchPublic() error {
+	req, err := http.NewRequest(http.MethodGet, s.URL+"/public?key="+url.QueryEscape(s.Key), nil)
+	if err != nil {
+		return err
+	}
+	data, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return fmt.Errorf("remote: server did not return a public key")
+	}
+	s.public, err = parsePublicKey(block.Bytes)
+	return err
+}
+
+// Return the public key of the key on the Server.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Send the digest to the Server to be signed. The rand argument is not
+// used, since the Server has its own entropy source. RSA-PSS isn't
+// supported.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, fmt.Errorf("remote: RSA-PSS is not supported")
+	}
+	hash, err := hashName(opts.HashFunc())
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(SignRequest{Key: s.Key, Hash: hash, Digest: digest})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL+"/sign", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	data, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := SignResponse{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
@@ -0,0 +1,11 @@
+// A remote signing server, which signs digests with keys that never leave
+// the signing host, and a crypto.Signer client for it, which can be passed to
+// ima.Sign or xattr.Sign like any other crypto.Signer.
+//
+// Clients authenticate with a bearer token, and each client may only use the
+// keys it has been allowed. The server only ever sees digests, never files.
+//
+// The protocol is JSON over HTTP. Signing is a POST to /sign of a SignRequest,
+// which returns a SignResponse. The PKIX public key of a key is fetched with a
+// GET of /public?key=name, which returns a PEM "PUBLIC KEY" block.
+package remote
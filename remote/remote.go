@@ -0,0 +1,99 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"crypto"
+	"fmt"
+
+	"crypto/x509"
+
+	"pault.ag/go/ima"
+	"pault.ag/go/ima/ecrdsa"
+	"pault.ag/go/ima/sm2"
+)
+
+// Request to sign a digest.
+type SignRequest struct {
+	// Name of the key to sign with. If empty, the client's default key is
+	// used.
+	Key string `json:"key,omitempty"`
+
+	// IMA EVM name of the Hash function the digest was computed with, such
+	// as "sha256", or empty if the digest should be signed as-is, as for
+	// SM2 keys.
+	Hash string `json:"hash,omitempty"`
+
+	// Digest to sign.
+	Digest []byte `json:"digest"`
+}
+
+// Response to a SignRequest.
+type SignResponse struct {
+	// Name of the key that made the Signature.
+	Key string `json:"key"`
+
+	// Signature over the digest, as returned by the crypto.Signer.
+	Signature []byte `json:"signature"`
+}
+
+// Convert a crypto.Hash to the name used in a SignRequest.
+func hashName(hash crypto.Hash) (string, error) {
+	if hash == 0 {
+		return "", nil
+	}
+	imaHash, err := ima.HashFunctions.ToHash(hash)
+	if err != nil {
+		return "", err
+	}
+	return imaHash.Name, nil
+}
+
+// Convert the name used in a SignRequest to a crypto.Hash.
+func parseHashName(name string) (crypto.Hash, error) {
+	if name == "" {
+		return 0, nil
+	}
+	for _, imaHash := range ima.HashFunctions {
+		if imaHash.Name == name && imaHash.Hash != 0 {
+			return imaHash.Hash, nil
+		}
+	}
+	return 0, fmt.Errorf("remote: unknown hash %q", name)
+}
+
+// Encode a public key as a PKIX SubjectPublicKeyInfo, including the SM2
+// and EC-RDSA keys that crypto/x509 doesn't know about.
+func marshalPublicKey(pub crypto.PublicKey) ([]byte, error) {
+	switch pub := pub.(type) {
+	case *sm2.PublicKey:
+		return sm2.MarshalPKIXPublicKey(pub)
+	case *ecrdsa.PublicKey:
+		return ecrdsa.MarshalPKIXPublicKey(pub)
+	default:
+		return x509.MarshalPKIXPublicKey(pub)
+	}
+}
+
+// Parse a PKIX SubjectPublicKeyInfo made by marshalPublicKey.
+func parsePublicKey(der []byte) (crypto.PublicKey, error) {
+	if pub, err := sm2.ParsePKIXPublicKey(der); err == nil {
+		return pub, nil
+	}
+	if pub, err := ecrdsa.ParsePKIXPublicKey(der); err == nil {
+		return pub, nil
+	}
+	return x509.ParsePKIXPublicKey(der)
+}
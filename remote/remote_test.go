@@ -0,0 +1,170 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote_test
+
+import (
+	"bytes"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+
+	"pault.ag/go/ima"
+	"pault.ag/go/ima/ecrdsa"
+	"pault.ag/go/ima/remote"
+	"pault.ag/go/ima/sm2"
+)
+
+func TestRemoteSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	isok(t, err)
+
+	logs := bytes.Buffer{}
+	server := httptest.NewServer(&remote.Server{
+		Keys: map[string]crypto.Signer{
+			"release": rsaKey,
+			"hsm":     ecdsaKey,
+		},
+		Clients: []remote.Client{
+			{Name: "builder", Token: "builder-token", Keys: []string{"release", "hsm"}},
+			{Name: "ci", Token: "ci-token", Keys: []string{"hsm"}},
+		},
+		Logger: log.New(&logs, "", 0),
+	})
+	defer server.Close()
+
+	digest := sha256.Sum256([]byte("totally legit elf af"))
+
+	for _, test := range []struct {
+		Token string
+		Key   string
+	}{
+		{"builder-token", ""},
+		{"builder-token", "hsm"},
+		{"ci-token", ""},
+	} {
+		signer, err := remote.NewSigner(server.URL, test.Token, test.Key)
+		isok(t, err)
+
+		sigBytes, err := ima.Sign(signer, rand.Reader, digest[:], crypto.SHA256)
+		isok(t, err)
+		sig, err := ima.Parse(sigBytes)
+		isok(t, err)
+
+		keys := ima.NewKeyPool()
+		isok(t, keys.AddKey(signer.Public()))
+		_, err = sig.Verify(ima.VerifyOptions{
+			Digest: digest[:],
+			Hash:   crypto.SHA256,
+			Keys:   keys,
+		})
+		isok(t, err)
+	}
+
+	_, err = remote.NewSigner(server.URL, "ci-token", "release")
+	notok(t, err)
+	_, err = remote.NewSigner(server.URL, "wrong-token", "")
+	notok(t, err)
+
+	signer, err := remote.NewSigner(server.URL, "ci-token", "")
+	isok(t, err)
+	_, err = signer.Sign(rand.Reader, digest[:16], crypto.SHA256)
+	notok(t, err)
+
+	assert(t, strings.Contains(logs.String(), "builder: signed sha256"))
+	assert(t, strings.Contains(logs.String(), "ci: signed sha256"))
+	assert(t, strings.Contains(logs.String(), "unauthorized"))
+	assert(t, strings.Contains(logs.String(), `key "release" is not allowed`))
+}
+
+func TestServerRawDigest(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+
+	server := httptest.NewServer(&remote.Server{
+		Keys:    map[string]crypto.Signer{"release": rsaKey},
+		Clients: []remote.Client{{Name: "builder", Token: "builder-token", Keys: []string{"release"}}},
+		Logger:  log.New(&bytes.Buffer{}, "", 0),
+	})
+	defer server.Close()
+
+	digest := sha256.Sum256([]byte("totally legit elf af"))
+
+	// Without a hash, an RSA key would sign whatever bytes it was sent.
+	signer, err := remote.NewSigner(server.URL, "builder-token", "release")
+	isok(t, err)
+	_, err = signer.Sign(rand.Reader, []byte("not a digest at all"), crypto.Hash(0))
+	notok(t, err)
+	_, err = signer.Sign(rand.Reader, digest[:], crypto.Hash(0))
+	notok(t, err)
+}
+
+func TestRemoteSignerRawDigest(t *testing.T) {
+	sm2Key, err := sm2.GenerateKey(rand.Reader)
+	isok(t, err)
+	ecrdsaKey, err := ecrdsa.GenerateKey(ecrdsa.TC26512A(), rand.Reader)
+	isok(t, err)
+
+	server := httptest.NewServer(&remote.Server{
+		Keys: map[string]crypto.Signer{
+			"sm2":    sm2Key,
+			"ecrdsa": ecrdsaKey,
+		},
+		Clients: []remote.Client{
+			{Name: "builder", Token: "builder-token", Keys: []string{"sm2", "ecrdsa"}},
+		},
+		Logger: log.New(&bytes.Buffer{}, "", 0),
+	})
+	defer server.Close()
+
+	// SM2 and EC-RDSA keys hash the digest themselves, so they're sent
+	// without a crypto.Hash.
+	for _, test := range []struct {
+		Key    string
+		Public crypto.PublicKey
+		Hash   ima.Hash
+	}{
+		{"sm2", sm2Key.Public(), ima.SM3},
+		{"ecrdsa", ecrdsaKey.Public(), ima.Streebog512},
+	} {
+		signer, err := remote.NewSigner(server.URL, "builder-token", test.Key)
+		isok(t, err)
+		assert(t, signer.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(test.Public))
+
+		hash, err := test.Hash.New()
+		isok(t, err)
+		hash.Write([]byte("totally legit elf af"))
+		digest := hash.Sum(nil)
+
+		sigBytes, err := ima.Sign(signer, rand.Reader, digest, ima.SignatureOptions{Hash: test.Hash})
+		isok(t, err)
+		sig, err := ima.Parse(sigBytes)
+		isok(t, err)
+
+		keys := ima.NewKeyPool()
+		isok(t, keys.AddKey(test.Public))
+		_, err = sig.Verify(ima.VerifyOptions{Digest: digest, Keys: keys})
+		isok(t, err)
+	}
+}
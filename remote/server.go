@@ -0,0 +1,199 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+
+	"crypto"
+	"crypto/rand"
+	"crypto/subtle"
+
+	"pault.ag/go/ima/ecrdsa"
+	"pault.ag/go/ima/sm2"
+)
+
+// Requests larger than this are refused.
+const maxRequestSize = 64 << 10
+
+// Client of a Server, and the keys it's allowed to use.
+type Client struct {
+	// Name of the client, used in the request log.
+	Name string
+
+	// Bearer token the client authenticates with.
+	Token string
+
+	// Names of the keys this client is allowed to sign with. The first one
+	// is used when a request doesn't name a key.
+	Keys []string
+}
+
+// Server is an http.Handler that signs digests for authenticated Clients.
+type Server struct {
+	// Signers, by key name.
+	Keys map[string]crypto.Signer
+
+	// Clients allowed to use the Server.
+	Clients []Client
+
+	// Log of every request. If nil, the log package's standard logger is
+	// used.
+	Logger *log.Logger
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// Find the Client with the bearer token from the request.
+func (s *Server) authenticate(req *http.Request) *Client {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return nil
+	}
+	for i := range s.Clients {
+		client := &s.Clients[i]
+		if subtle.ConstantTimeCompare([]byte(client.Token), []byte(token)) == 1 {
+			return client
+		}
+	}
+	return nil
+}
+
+// Pick the key for the Client, by name, or the Client's default key.
+func (s *Server) key(client *Client, name string) (string, crypto.Signer, error) {
+	if len(client.Keys) == 0 {
+		return "", nil, fmt.Errorf("client has no keys")
+	}
+	if name == "" {
+		name = client.Keys[0]
+	}
+	for _, allowed := range client.Keys {
+		if allowed == name {
+			signer, ok := s.Keys[name]
+			if !ok {
+				return "", nil, fmt.Errorf("unknown key %q", name)
+			}
+			return name, signer, nil
+		}
+	}
+	return "", nil, fmt.Errorf("key %q is not allowed", name)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	client := s.authenticate(req)
+	if client == nil {
+		s.logf("remote: %s: %s %s: unauthorized", req.RemoteAddr, req.Method, req.URL.Path)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case req.URL.Path == "/sign" && req.Method == http.MethodPost:
+		s.sign(w, req, client)
+	case req.URL.Path == "/public" && req.Method == http.MethodGet:
+		s.public(w, req, client)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (s *Server) sign(w http.ResponseWriter, req *http.Request, client *Client) {
+	signReq := SignRequest{}
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxRequestSize)).Decode(&signReq); err != nil {
+		s.logf("remote: %s: %s: bad request: %s", req.RemoteAddr, client.Name, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	name, signer, err := s.key(client, signReq.Key)
+	if err != nil {
+		s.logf("remote: %s: %s: sign: %s", req.RemoteAddr, client.Name, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	hash, err := checkDigest(signer, signReq)
+	if err != nil {
+		s.logf("remote: %s: %s: sign with %s: %s", req.RemoteAddr, client.Name, name, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	signature, err := signer.Sign(rand.Reader, signReq.Digest, hash)
+	if err != nil {
+		s.logf("remote: %s: %s: sign %s %x with %s: %s",
+			req.RemoteAddr, client.Name, signReq.Hash, signReq.Digest, name, err)
+		http.Error(w, "signing failed", http.StatusInternalServerError)
+		return
+	}
+	s.logf("remote: %s: %s: signed %s %s with %s",
+		req.RemoteAddr, client.Name, signReq.Hash, hex.EncodeToString(signReq.Digest), name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SignResponse{Key: name, Signature: signature})
+}
+
+// Find the crypto.Hash to sign the request's digest with. Only SM2 and
+// EC-RDSA keys hash the digest themselves, so any other key needs a known
+// Hash and a digest of the right size, or the Server would sign whatever
+// bytes the client sent.
+func checkDigest(signer crypto.Signer, signReq SignRequest) (crypto.Hash, error) {
+	hash, err := parseHashName(signReq.Hash)
+	if err != nil {
+		return 0, err
+	}
+	if hash == 0 {
+		switch signer.Public().(type) {
+		case *sm2.PublicKey, *ecrdsa.PublicKey:
+			return 0, nil
+		}
+		return 0, fmt.Errorf("a hash is required for this key")
+	}
+	if len(signReq.Digest) != hash.Size() {
+		return 0, fmt.Errorf("digest is not a %s digest", signReq.Hash)
+	}
+	return hash, nil
+}
+
+func (s *Server) public(w http.ResponseWriter, req *http.Request, client *Client) {
+	name, signer, err := s.key(client, req.URL.Query().Get("key"))
+	if err != nil {
+		s.logf("remote: %s: %s: public key: %s", req.RemoteAddr, client.Name, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	der, err := marshalPublicKey(signer.Public())
+	if err != nil {
+		s.logf("remote: %s: %s: public key %s: %s", req.RemoteAddr, client.Name, name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
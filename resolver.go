@@ -0,0 +1,47 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ima
+
+import (
+	"crypto"
+	"crypto/x509"
+)
+
+// A key which might have made a Signature, as returned by a KeyResolver.
+type Candidate struct {
+	Key crypto.PublicKey
+
+	// Certificate the Key came from, or nil if the Key was added bare.
+	Certificate *x509.Certificate
+}
+
+// KeyResolver finds the keys that might have made a Signature, by KeyId.
+// This allows keys to be looked up lazily, from a database, a directory,
+// or a key server, rather than all being loaded into a KeyPool up front.
+//
+// KeyPool is a KeyResolver, as is the key server client in the httpkeys
+// package.
+type KeyResolver interface {
+	// Return all the keys with the KeyId. If there aren't any, this should
+	// return an empty list, rather than an error; errors are for failing to
+	// look the keys up at all.
+	Resolve(id [4]byte) ([]Candidate, error)
+}
+
+// KeyResolverV1 is implemented by KeyResolvers that can also find keys by
+// version 1 KeyId, which is needed to verify a SignatureV1.
+type KeyResolverV1 interface {
+	ResolveV1(id [8]byte) ([]Candidate, error)
+}
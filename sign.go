@@ -17,33 +17,156 @@ package ima
 import (
 	"fmt"
 	"io"
+	"math/big"
+	"time"
+
+	"encoding/asn1"
 
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+
+	"pault.ag/go/ima/ecrdsa"
+	"pault.ag/go/ima/sm2"
 )
 
+// Options to control how an IMA Signature is created. This implements
+// crypto.SignerOpts, so it may be passed anywhere a crypto.SignerOpts is
+// accepted, such as Sign, or xattr.Sign.
+//
+// Passing a plain crypto.Hash as the crypto.SignerOpts is the same as passing
+// a SignatureOptions with only the Hash set.
+type SignatureOptions struct {
+	// IMA EVM Hash algorithm the digest was computed with.
+	Hash Hash
+
+	// Signature format to create, either SignatureVersion2 or
+	// SignatureVersion3. If this is not set, a version 2 Signature will be
+	// created.
+	Version uint8
+
+	// xattr type the Signature will be stored as, one of XattrDigSig,
+	// XattrPortableDigSig or XattrVerityDigSig. Version 3 signatures include
+	// this in the signed data. If this is not set, XattrDigSig is used.
+	Type uint8
+
+	// Certificate of the signing key. If this is set, the Key ID will be
+	// taken from the Certificate with CertificateKeyId, rather than being
+	// computed from the crypto.Signer's Public Key. This is needed when the
+	// Certificate's Subject Key Identifier wasn't generated in the usual way.
+	Certificate *x509.Certificate
+}
+
+// Return the crypto.Hash the digest was computed with, in order to implement
+// crypto.SignerOpts.
+func (o SignatureOptions) HashFunc() crypto.Hash {
+	return o.Hash.Hash
+}
+
+// Get the IMA EVM Hash that a crypto.SignerOpts will sign with. This is the
+// Hash field of a SignatureOptions, or the IMA EVM Hash matching the
+// crypto.Hash of any other crypto.SignerOpts.
+//
+// This is handy to measure a file before calling Sign, since Hash.New() works
+// for Hash functions without a native Go crypto.Hash, such as SM3.
+func SignerHash(opts crypto.SignerOpts) (*Hash, error) {
+	sigOpts, err := signatureOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sigOpts.Hash, nil
+}
+
+// Turn any crypto.SignerOpts into a SignatureOptions, filling in defaults
+// for anything that isn't set.
+func signatureOptions(opts crypto.SignerOpts) (*SignatureOptions, error) {
+	var ret SignatureOptions
+	switch o := opts.(type) {
+	case SignatureOptions:
+		ret = o
+	case *SignatureOptions:
+		ret = *o
+	default:
+		imaHash, err := HashFunctions.ToHash(opts.HashFunc())
+		if err != nil {
+			return nil, err
+		}
+		ret.Hash = *imaHash
+	}
+
+	if ret.Version == 0 {
+		ret.Version = SignatureVersion2
+	}
+	if ret.Type == 0 {
+		ret.Type = XattrDigSig
+	}
+
+	switch ret.Type {
+	case XattrDigSig, XattrPortableDigSig:
+	case XattrVerityDigSig:
+		if ret.Version != SignatureVersion3 {
+			return nil, fmt.Errorf("ima: fs-verity signatures must be version 3")
+		}
+	default:
+		return nil, fmt.Errorf("ima: unknown signature xattr type %x", ret.Type)
+	}
+	return &ret, nil
+}
+
 // Given a crypto.Signer, a RNG source (to be used during the underlying
 // signer.Sign call), a digest, and a crypto.SignerOpts, sign the digest
-// and serialize the Signature as an IMA EVM v2.0 signature.
+// and serialize the Signature as an IMA EVM Signature.
+//
+// If opts is a SignatureOptions, the Signature version and xattr type will
+// be taken from it, otherwise an IMA EVM v2.0 signature is created.
 func Sign(signer crypto.Signer, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
-	imaHash, err := HashFunctions.ToHash(opts.HashFunc())
+	sigOpts, err := signatureOptions(opts)
 	if err != nil {
 		return nil, err
 	}
+	switch opts.(type) {
+	case SignatureOptions, *SignatureOptions:
+		// The underlying crypto.Signer has no idea what to do with our
+		// options, so hand it the plain crypto.Hash.
+		opts = sigOpts.Hash.Hash
+	}
+
+	if sigOpts.Hash.Hash == 0 {
+		// SM3 and Streebog have no crypto.Hash; only SM2 and EC-RDSA
+		// signers know to sign the digest as-is. Anything else would
+		// happily produce a signature with no DigestInfo at all.
+		switch signer.Public().(type) {
+		case *sm2.PublicKey, *ecrdsa.PublicKey:
+		default:
+			return nil, fmt.Errorf("ima: %s digests can only be signed by SM2 or EC-RDSA keys", sigOpts.Hash.Name)
+		}
+	}
 
-	keyId, err := PublicKeyId(signer.Public())
+	var keyId [4]byte
+	if sigOpts.Certificate != nil {
+		keyId, err = CertificateKeyId(sigOpts.Certificate)
+	} else {
+		keyId, err = PublicKeyId(signer.Public())
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	ret := Signature{Header: SignatureHeader{
-		Magic:         0x03,
-		Version:       0x02,
-		HashAlgorithm: imaHash.Id,
+		Magic:         sigOpts.Type,
+		Version:       sigOpts.Version,
+		HashAlgorithm: sigOpts.Hash.Id,
 		KeyID:         keyId,
 	}}
 
-	signature, err := signer.Sign(rand, digest, opts)
+	signedDigest, err := ret.SignedDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := signer.Sign(rand, signedDigest, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -66,55 +189,225 @@ type VerifyOptions struct {
 	// `Signature.Header.Hash()` function call.
 	Hash crypto.Hash
 
-	// Keyring to validate Signatures against.
-	Keys KeyPool
+	// Keyring to validate Signatures against. This is usually a KeyPool.
+	Keys KeyResolver
+
+	// What to do about the Certificate of the key that made the Signature,
+	// if the key was added with one. By default, Certificates aren't
+	// checked. See VerifyOptions.CheckCertificate for the checks made.
+	CertificatePolicy CertificatePolicy
+
+	// Time to check Certificate validity at. If this is the zero time, the
+	// current time is used.
+	CurrentTime time.Time
+
+	// Extended key usages that a Certificate with an extKeyUsage extension
+	// must have one of. If this is empty, x509.ExtKeyUsageCodeSigning is
+	// required.
+	ExtKeyUsages []x509.ExtKeyUsage
+
+	// Called with the CertificateError when the CertificatePolicy is
+	// CertificatesWarn and a Certificate fails a check.
+	Warn func(error)
+
+	// Revoked keys, Certificates and file digests. If this is nil, nothing
+	// is revoked.
+	Blocklist *Blocklist
 }
 
 var (
-	// This is returned when the KeyPool does not have the KeyId in the
+	// This is returned when the KeyResolver does not have the KeyId in the
 	// keychain, which means there's absolutely no way we have a valid
 	// Signature, since we absolutely don't have the public key.
 	UnknownSigner error = fmt.Errorf("ima: unknown signature keyid")
+
+	// This is returned when a key made the Signature over a different
+	// digest, which usually means the file has changed since it was signed.
+	// This can only be told apart from a BadSignature for RSA keys, where
+	// the signed digest can be recovered from the Signature.
+	DigestMismatch error = fmt.Errorf("ima: signature is over a different digest")
+
+	// This is returned when the Signature doesn't check out against the key.
+	BadSignature error = fmt.Errorf("ima: bad signature")
+
+	// This is returned when the Signature version, or the type of the public
+	// key, isn't supported.
+	UnsupportedAlgorithm error = fmt.Errorf("ima: unsupported algorithm")
 )
 
 // Verify the Signature with the provided VerifyOptions.
 //
-// If the KeyId is unknown to the underlying KeyPool, this will return
-// UnknownSigner.
+// If the KeyId is unknown to the underlying KeyResolver, this will return
+// UnknownSigner. If the Digest is in the Blocklist, this will return
+// RevokedDigest, and if the key that made the Signature, or its
+// Certificate, is in the Blocklist, RevokedKey.
 //
 // This function will attempt to verify the signature using each of the
-// Public keys with a matching KeyId in the order they were added to the
-// Pool. When a Signature matches, the Public Key will be returned.
-// If not, the error from the last validation attempt will be returned.
+// Public keys with a matching KeyId in the order the KeyResolver returned
+// them, which for a KeyPool is the order they were added. When a Signature
+// matches, and the key's Certificate passes the
+// CertificatePolicy, the Public Key will be returned. If not, the error from
+// the last validation attempt will be returned, which will be a
+// CertificateError if the Signature was valid, but the Certificate wasn't.
 func (s Signature) Verify(opts VerifyOptions) (crypto.PublicKey, error) {
-	candidates := opts.Keys.Get(s.Header.KeyID)
+	candidate, err := s.Match(opts)
+	if err != nil {
+		return nil, err
+	}
+	return candidate.Key, nil
+}
+
+// Verify the Signature the same way as Verify, but return the Candidate that
+// made it, which includes the key's Certificate, if it has one.
+func (s Signature) Match(opts VerifyOptions) (*Candidate, error) {
+	if opts.Blocklist != nil && opts.Blocklist.BlocksDigest(opts.Digest) {
+		return nil, RevokedDigest
+	}
+	if opts.Keys == nil {
+		return nil, UnknownSigner
+	}
+	candidates, err := opts.Keys.Resolve(s.Header.KeyID)
+	if err != nil {
+		return nil, err
+	}
 	if len(candidates) == 0 {
 		return nil, UnknownSigner
 	}
-	var err error
+	// A candidate that made the signature but was turned away for some
+	// other reason is a far more useful error than a later candidate's
+	// BadSignature.
+	var verifyErr, checkErr error
 	for _, el := range candidates {
-		if err = s.VerifyKey(el, opts.Digest, opts.Hash); err == nil {
-			return el, nil
+		if err := s.VerifyKey(el.Key, opts.Digest, opts.Hash); err != nil {
+			verifyErr = err
+			continue
 		}
+		if err := opts.checkCandidate(el); err != nil {
+			checkErr = err
+			continue
+		}
+		return &el, nil
+	}
+	if checkErr != nil {
+		return nil, checkErr
 	}
-	return nil, err
+	return nil, verifyErr
 }
 
 // Verify the Signature against the digest matches both our digest and hash
 // algorithm for a specific key.
 //
-// At this time, only RSA Public Keys are supported due to IMA only supporting
-// RSA signatures.
+// The digest is always the digest of the file, even for version 3 signatures,
+// where the ima_file_id will be computed internally.
 //
-// Any PublicKey struct except an rsa.PublicKey will return an opaque error.
+// At this time, RSA Public Keys, and ECDSA or SM2 Public Keys with ASN.1 DER
+// encoded signatures are supported. SM2 signatures are checked over the SM3
+// hash of the signer's Z value and the digest; see the sm2 package. EC-RDSA
+// Public Keys are supported with s || r encoded signatures over a Streebog
+// digest; see the ecrdsa package.
+//
+// If the Signature doesn't check out, an error wrapping BadSignature, or for
+// RSA keys, DigestMismatch, is returned. Any other PublicKey struct will
+// return an error wrapping UnsupportedAlgorithm.
 func (s Signature) VerifyKey(pub crypto.PublicKey, digest []byte, hash crypto.Hash) error {
+	signedDigest, err := s.SignedDigest(digest)
+	if err != nil {
+		return err
+	}
+
 	switch pub.(type) {
 	case rsa.PublicKey:
 		pubRSA := pub.(rsa.PublicKey)
-		return rsa.VerifyPKCS1v15(&pubRSA, hash, digest, s.Signature)
+		return verifyRSA(&pubRSA, hash, signedDigest, s.Signature)
 	case *rsa.PublicKey:
-		return rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), hash, digest, s.Signature)
+		return verifyRSA(pub.(*rsa.PublicKey), hash, signedDigest, s.Signature)
+	case ecdsa.PublicKey:
+		pubECDSA := pub.(ecdsa.PublicKey)
+		return s.VerifyKey(&pubECDSA, digest, hash)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), signedDigest, s.Signature) {
+			return fmt.Errorf("%w: ecdsa verification error", BadSignature)
+		}
+		return nil
+	case *sm2.PublicKey:
+		if !sm2.VerifyASN1(pub.(*sm2.PublicKey), signedDigest, s.Signature) {
+			return fmt.Errorf("%w: sm2 verification error", BadSignature)
+		}
+		return nil
+	case *ecrdsa.PublicKey:
+		if !ecrdsa.Verify(pub.(*ecrdsa.PublicKey), signedDigest, s.Signature) {
+			return fmt.Errorf("%w: ecrdsa verification error", BadSignature)
+		}
+		return nil
 	default:
-		return fmt.Errorf("ima: PublicKey format not understood")
+		return fmt.Errorf("%w: PublicKey format not understood", UnsupportedAlgorithm)
+	}
+}
+
+// Get the data the Signature is actually made over, given the digest of the
+// file. For version 2 signatures, this is the digest itself. For version 3
+// signatures, this is the FileIdDigest of the digest.
+func (s Signature) SignedDigest(digest []byte) ([]byte, error) {
+	switch s.Header.Version {
+	case SignatureVersion2:
+		return digest, nil
+	case SignatureVersion3:
+		imaHash, err := HashFunctions.Lookup(s.Header.HashAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		return FileIdDigest(s.Header.Magic, *imaHash, digest)
+	default:
+		return nil, fmt.Errorf("%w: signature version %d is not supported", UnsupportedAlgorithm, s.Header.Version)
+	}
+}
+
+// Check a PKCS#1 v1.5 Signature over the signed digest. If it doesn't check
+// out, the digest the Signature was made over is recovered, to tell a
+// DigestMismatch apart from a BadSignature.
+func verifyRSA(pub *rsa.PublicKey, hash crypto.Hash, signedDigest, signature []byte) error {
+	if err := rsa.VerifyPKCS1v15(pub, hash, signedDigest, signature); err == nil {
+		return nil
+	}
+	recovered := recoverRSA(pub, hash, signature)
+	if recovered != nil && len(recovered) == len(signedDigest) {
+		return DigestMismatch
+	}
+	return BadSignature
+}
+
+// Recover the digest a PKCS#1 v1.5 Signature was made over, or nil if it
+// isn't a well formed Signature by this key. If the hash is 0, the digest
+// was signed without a DigestInfo.
+func recoverRSA(pub *rsa.PublicKey, hash crypto.Hash, signature []byte) []byte {
+	c := new(big.Int).SetBytes(signature)
+	if len(signature) != pub.Size() || c.Cmp(pub.N) >= 0 {
+		return nil
+	}
+	em := c.Exp(c, big.NewInt(int64(pub.E)), pub.N).FillBytes(make([]byte, pub.Size()))
+
+	// 0x00 0x01 0xff... 0x00 T
+	if em[0] != 0x00 || em[1] != 0x01 {
+		return nil
+	}
+	i := 2
+	for i < len(em) && em[i] == 0xff {
+		i++
+	}
+	if i == 2 || i == len(em) || em[i] != 0x00 {
+		return nil
+	}
+	t := em[i+1:]
+	if hash == 0 {
+		return t
+	}
+
+	digestInfo := struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Digest    []byte
+	}{}
+	if rest, err := asn1.Unmarshal(t, &digestInfo); err != nil || len(rest) != 0 {
+		return nil
 	}
+	return digestInfo.Digest
 }
@@ -15,14 +15,23 @@
 package ima_test
 
 import (
+	"errors"
+
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
 
 	"testing"
 
 	"pault.ag/go/ima"
+	"pault.ag/go/ima/ecrdsa"
+	"pault.ag/go/ima/sm2"
+	"pault.ag/go/ima/sm3"
 )
 
 func TestSign(t *testing.T) {
@@ -84,3 +93,313 @@ func TestSignPool(t *testing.T) {
 	isok(t, err)
 	assert(t, key.Public() == usedKey)
 }
+
+func TestSignV3(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+
+	hash := sha256.New()
+	hash.Write([]byte("Totally real ELF no tricks"))
+	digest := hash.Sum(nil)
+
+	sigBytes, err := ima.Sign(key, rand.Reader, digest, ima.SignatureOptions{
+		Hash:    ima.SHA256,
+		Version: ima.SignatureVersion3,
+	})
+	isok(t, err)
+
+	sig, err := ima.Parse(sigBytes)
+	isok(t, err)
+
+	assert(t, sig.Header.Magic == ima.XattrDigSig)
+	assert(t, sig.Header.Version == ima.SignatureVersion3)
+	assert(t, sig.Header.HashAlgorithm == ima.SHA256.Id)
+
+	isok(t, sig.VerifyKey(key.PublicKey, digest, crypto.SHA256))
+
+	fileId, err := ima.FileIdDigest(ima.XattrDigSig, ima.SHA256, digest)
+	isok(t, err)
+	isok(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, fileId, sig.Signature))
+	notok(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest, sig.Signature))
+}
+
+func TestSignVerity(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+
+	hash := sha256.New()
+	hash.Write([]byte("Totally real fs-verity descriptor"))
+	digest := hash.Sum(nil)
+
+	_, err = ima.Sign(key, rand.Reader, digest, ima.SignatureOptions{
+		Hash: ima.SHA256,
+		Type: ima.XattrVerityDigSig,
+	})
+	notok(t, err)
+
+	sigBytes, err := ima.Sign(key, rand.Reader, digest, &ima.SignatureOptions{
+		Hash:    ima.SHA256,
+		Version: ima.SignatureVersion3,
+		Type:    ima.XattrVerityDigSig,
+	})
+	isok(t, err)
+
+	sig, err := ima.Parse(sigBytes)
+	isok(t, err)
+
+	assert(t, sig.Header.Magic == ima.XattrVerityDigSig)
+	isok(t, sig.VerifyKey(&key.PublicKey, digest, crypto.SHA256))
+
+	// The xattr type is part of the signed data, so a verity signature
+	// can't be passed off as a regular one.
+	sig.Header.Magic = ima.XattrDigSig
+	notok(t, sig.VerifyKey(&key.PublicKey, digest, crypto.SHA256))
+}
+
+func TestSignECDSA(t *testing.T) {
+	for _, curve := range []elliptic.Curve{
+		elliptic.P256(),
+		elliptic.P384(),
+		elliptic.P521(),
+	} {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		isok(t, err)
+
+		pool := ima.NewKeyPool()
+		isok(t, pool.AddKey(key.Public()))
+
+		digest := sha512.Sum384([]byte("Totally real ELF no tricks"))
+
+		sigBytes, err := ima.Sign(key, rand.Reader, digest[:], crypto.SHA384)
+		isok(t, err)
+
+		sig, err := ima.Parse(sigBytes)
+		isok(t, err)
+		assert(t, ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig.Signature))
+
+		usedKey, err := sig.Verify(ima.VerifyOptions{
+			Keys:   pool,
+			Digest: digest[:],
+			Hash:   crypto.SHA384,
+		})
+		isok(t, err)
+		assert(t, key.Public() == usedKey)
+
+		notok(t, sig.VerifyKey(key.PublicKey, digest[1:], crypto.SHA384))
+	}
+}
+
+func TestSignSM2(t *testing.T) {
+	key, err := sm2.GenerateKey(rand.Reader)
+	isok(t, err)
+
+	pool := ima.NewKeyPool()
+	isok(t, pool.AddKey(key.Public()))
+
+	digest := sm3.Sum([]byte("Totally real ELF no tricks"))
+
+	sigBytes, err := ima.Sign(key, rand.Reader, digest[:], ima.SignatureOptions{
+		Hash:    ima.SM3,
+		Version: ima.SignatureVersion3,
+	})
+	isok(t, err)
+
+	sig, err := ima.Parse(sigBytes)
+	isok(t, err)
+	assert(t, sig.Header.HashAlgorithm == 17)
+
+	_, err = sig.Header.Hash()
+	notok(t, err)
+	imaHash, err := sig.Header.Algorithm()
+	isok(t, err)
+	assert(t, *imaHash == ima.SM3)
+
+	usedKey, err := sig.Verify(ima.VerifyOptions{
+		Keys:   pool,
+		Digest: digest[:],
+	})
+	isok(t, err)
+	assert(t, key.Public() == usedKey)
+
+	notok(t, sig.VerifyKey(key.Public(), digest[1:], 0))
+}
+
+func TestSignRawDigestRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+
+	digest := sm3.Sum([]byte("Totally real ELF no tricks"))
+
+	// RSA has no idea how to encode an SM3 DigestInfo, so this must fail
+	// rather than hand back a raw PKCS#1 v1.5 signature.
+	_, err = ima.Sign(key, rand.Reader, digest[:], ima.SignatureOptions{
+		Hash: ima.SM3,
+	})
+	notok(t, err)
+
+	hash, err := ima.Streebog256.New()
+	isok(t, err)
+	hash.Write([]byte("Totally real ELF no tricks"))
+	_, err = ima.Sign(key, rand.Reader, hash.Sum(nil), ima.SignatureOptions{
+		Hash:    ima.Streebog256,
+		Version: ima.SignatureVersion3,
+	})
+	notok(t, err)
+}
+
+func TestSignECRDSA(t *testing.T) {
+	for _, el := range []struct {
+		curve elliptic.Curve
+		hash  ima.Hash
+	}{
+		{curve: ecrdsa.CryptoProA(), hash: ima.Streebog256},
+		{curve: ecrdsa.TC26512A(), hash: ima.Streebog512},
+	} {
+		key, err := ecrdsa.GenerateKey(el.curve, rand.Reader)
+		isok(t, err)
+
+		pool := ima.NewKeyPool()
+		isok(t, pool.AddKey(key.Public()))
+
+		hash, err := el.hash.New()
+		isok(t, err)
+		hash.Write([]byte("Totally real ELF no tricks"))
+		digest := hash.Sum(nil)
+
+		for _, version := range []uint8{ima.SignatureVersion2, ima.SignatureVersion3} {
+			sigBytes, err := ima.Sign(key, rand.Reader, digest, ima.SignatureOptions{
+				Hash:    el.hash,
+				Version: version,
+			})
+			isok(t, err)
+
+			sig, err := ima.Parse(sigBytes)
+			isok(t, err)
+			assert(t, sig.Header.HashAlgorithm == el.hash.Id)
+
+			usedKey, err := sig.Verify(ima.VerifyOptions{
+				Keys:   pool,
+				Digest: digest,
+			})
+			isok(t, err)
+			assert(t, key.Public() == usedKey)
+		}
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	isok(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+
+	digest := sha256.Sum256([]byte("Totally real ELF no tricks"))
+	changed := sha256.Sum256([]byte("Totally real ELF some tricks"))
+
+	sigBytes, err := ima.Sign(rsaKey, rand.Reader, digest[:], crypto.SHA256)
+	isok(t, err)
+	sig, err := ima.Parse(sigBytes)
+	isok(t, err)
+
+	// RSA signatures give away the digest they were made over.
+	err = sig.VerifyKey(rsaKey.Public(), changed[:], crypto.SHA256)
+	assert(t, errors.Is(err, ima.DigestMismatch))
+	err = sig.VerifyKey(other.Public(), digest[:], crypto.SHA256)
+	assert(t, errors.Is(err, ima.BadSignature))
+	err = sig.VerifyKey(ecdsaKey.Public(), digest[:], crypto.SHA256)
+	assert(t, errors.Is(err, ima.BadSignature))
+	err = sig.VerifyKey("not a key", digest[:], crypto.SHA256)
+	assert(t, errors.Is(err, ima.UnsupportedAlgorithm))
+
+	sigBytes, err = ima.Sign(ecdsaKey, rand.Reader, digest[:], crypto.SHA256)
+	isok(t, err)
+	sig, err = ima.Parse(sigBytes)
+	isok(t, err)
+	err = sig.VerifyKey(ecdsaKey.Public(), changed[:], crypto.SHA256)
+	assert(t, errors.Is(err, ima.BadSignature))
+
+	sig.Header.Version = 9
+	err = sig.VerifyKey(ecdsaKey.Public(), digest[:], crypto.SHA256)
+	assert(t, errors.Is(err, ima.UnsupportedAlgorithm))
+}
+
+func TestMatch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	cert := newTestKeyCert(t, nil, key, x509.Certificate{}).cert
+
+	pool := ima.NewKeyPool()
+	isok(t, pool.AddCertificate(cert))
+
+	digest := sha256.Sum256([]byte("Totally real ELF no tricks"))
+	sigBytes, err := ima.Sign(key, rand.Reader, digest[:], ima.SignatureOptions{
+		Hash:        ima.SHA256,
+		Certificate: cert,
+	})
+	isok(t, err)
+	sig, err := ima.Parse(sigBytes)
+	isok(t, err)
+
+	candidate, err := sig.Match(ima.VerifyOptions{Keys: pool, Digest: digest[:], Hash: crypto.SHA256})
+	isok(t, err)
+	assert(t, candidate.Certificate == cert)
+	assert(t, candidate.Key == cert.PublicKey)
+
+	changed := sha256.Sum256([]byte("Totally real ELF some tricks"))
+	_, err = sig.Match(ima.VerifyOptions{Keys: pool, Digest: changed[:], Hash: crypto.SHA256})
+	assert(t, errors.Is(err, ima.DigestMismatch))
+}
+
+// KeyResolver that hands back the same Candidates for any KeyId, to get
+// several keys behind one KeyId without having to find a collision.
+type candidateList []ima.Candidate
+
+func (c candidateList) Resolve(id [4]byte) ([]ima.Candidate, error) {
+	return c, nil
+}
+
+func (c candidateList) ResolveV1(id [8]byte) ([]ima.Candidate, error) {
+	return c, nil
+}
+
+func TestMatchRevokedCandidate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+
+	digest := sha256.Sum256([]byte("Totally real ELF no tricks"))
+	sigBytes, err := ima.Sign(key, rand.Reader, digest[:], crypto.SHA256)
+	isok(t, err)
+	sig, err := ima.Parse(sigBytes)
+	isok(t, err)
+
+	blocklist := ima.NewBlocklist()
+	blocklist.AddKeyId(sig.Header.KeyID)
+
+	// The key that made the Signature is revoked, and that's what should
+	// come back, not the BadSignature from the key after it.
+	_, err = sig.Match(ima.VerifyOptions{
+		Keys: candidateList{
+			{Key: key.Public()},
+			{Key: other.Public()},
+		},
+		Digest:    digest[:],
+		Hash:      crypto.SHA256,
+		Blocklist: blocklist,
+	})
+	assert(t, err == ima.RevokedKey)
+
+	_, err = sig.Match(ima.VerifyOptions{
+		Keys: candidateList{
+			{Key: other.Public()},
+			{Key: key.Public()},
+		},
+		Digest:    digest[:],
+		Hash:      crypto.SHA256,
+		Blocklist: blocklist,
+	})
+	assert(t, err == ima.RevokedKey)
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sm2
+
+import (
+	"fmt"
+	"math/big"
+
+	"encoding/asn1"
+
+	"crypto/x509/pkix"
+)
+
+var (
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidSM2            = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 301}
+)
+
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// Encode the Public Key as a PKIX SubjectPublicKeyInfo, the same way
+// OpenSSL does: an id-ecPublicKey on the SM2 named curve.
+func MarshalPKIXPublicKey(pub *PublicKey) ([]byte, error) {
+	params, err := asn1.Marshal(oidSM2)
+	if err != nil {
+		return nil, err
+	}
+	point := pub.Bytes()
+	return asn1.Marshal(subjectPublicKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPublicKeyECDSA,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		PublicKey: asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+	})
+}
+
+// Parse a PKIX SubjectPublicKeyInfo holding an SM2 Public Key, either as an
+// id-ecPublicKey on the SM2 named curve, or with the SM2 algorithm itself.
+func ParsePKIXPublicKey(der []byte) (*PublicKey, error) {
+	spki := subjectPublicKeyInfo{}
+	if rest, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("sm2: trailing data after public key")
+	}
+
+	switch {
+	case spki.Algorithm.Algorithm.Equal(oidSM2):
+	case spki.Algorithm.Algorithm.Equal(oidPublicKeyECDSA):
+		curve := asn1.ObjectIdentifier{}
+		if _, err := asn1.Unmarshal(spki.Algorithm.Parameters.FullBytes, &curve); err != nil || !curve.Equal(oidSM2) {
+			return nil, fmt.Errorf("sm2: public key is not on the SM2 curve")
+		}
+	default:
+		return nil, fmt.Errorf("sm2: not an SM2 public key")
+	}
+
+	curve := P256()
+	size := (curve.Params().BitSize + 7) / 8
+	point := spki.PublicKey.RightAlign()
+	if len(point) != 1+2*size || point[0] != 0x04 {
+		return nil, fmt.Errorf("sm2: unsupported public key point encoding")
+	}
+	pub := &PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(point[1 : 1+size]),
+		Y:     new(big.Int).SetBytes(point[1+size:]),
+	}
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, fmt.Errorf("sm2: public key is not on the curve")
+	}
+	return pub, nil
+}
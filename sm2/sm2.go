@@ -0,0 +1,218 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sm2 implements SM2 signatures, as defined in GB/T 32918-2016, over
+// the SM2 recommended curve. Signatures are ASN.1 DER encoded, and are made
+// over the SM3 hash of the signer's Z value followed by the message, which is
+// what the Linux kernel's SM2 verifier expects.
+package sm2
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"crypto/rand"
+
+	"pault.ag/go/ima/sm3"
+)
+
+var (
+	// User ID used to compute the Z value when none is otherwise agreed on.
+	// This is the value used by the Linux kernel, and by most other SM2
+	// implementations.
+	DefaultUID = []byte("1234567812345678")
+
+	initOnce sync.Once
+	sm2P256  *elliptic.CurveParams
+	one      = big.NewInt(1)
+)
+
+func initP256() {
+	sm2P256 = &elliptic.CurveParams{Name: "SM2-P-256", BitSize: 256}
+	sm2P256.P, _ = new(big.Int).SetString("FFFFFFFEFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF00000000FFFFFFFFFFFFFFFF", 16)
+	sm2P256.N, _ = new(big.Int).SetString("FFFFFFFEFFFFFFFFFFFFFFFFFFFFFFFF7203DF6B21C6052B53BBF40939D54123", 16)
+	sm2P256.B, _ = new(big.Int).SetString("28E9FA9E9D9F5E344D5A9E4BCF6509A7F39789F515AB8F92DDBCBD414D940E93", 16)
+	sm2P256.Gx, _ = new(big.Int).SetString("32C4AE2C1F1981195F9904466A39C9948FE30BBFF2660BE1715A4589334C74C7", 16)
+	sm2P256.Gy, _ = new(big.Int).SetString("BC3736A2F4F6779C59BDCEE36B692153D0A9877CC62A474002DF32E52139F0A0", 16)
+}
+
+// Return the SM2 recommended curve. Like the other 'a = -3' curves, the
+// generic elliptic.CurveParams implementation is used for the arithmetic.
+func P256() elliptic.Curve {
+	initOnce.Do(initP256)
+	return sm2P256
+}
+
+// SM2 Public Key.
+type PublicKey struct {
+	elliptic.Curve
+	X, Y *big.Int
+}
+
+// SM2 Private Key. This implements crypto.Signer, and may be passed to
+// ima.Sign.
+type PrivateKey struct {
+	PublicKey
+	D *big.Int
+}
+
+type signature struct {
+	R, S *big.Int
+}
+
+// Generate a new SM2 Private Key on the SM2 recommended curve.
+func GenerateKey(random io.Reader) (*PrivateKey, error) {
+	curve := P256()
+	// d must be in [1, n-2], since (1 + d) is inverted when signing.
+	d, err := rand.Int(random, new(big.Int).Sub(curve.Params().N, big.NewInt(2)))
+	if err != nil {
+		return nil, err
+	}
+	d.Add(d, one)
+
+	priv := PrivateKey{D: d}
+	priv.Curve = curve
+	priv.X, priv.Y = curve.ScalarBaseMult(d.Bytes())
+	return &priv, nil
+}
+
+// Return the uncompressed encoding of the Public Key point, which is the
+// subjectPublicKey of an SM2 certificate.
+func (pub *PublicKey) Bytes() []byte {
+	size := (pub.Params().BitSize + 7) / 8
+	ret := make([]byte, 1+2*size)
+	ret[0] = 0x04
+	pub.X.FillBytes(ret[1 : 1+size])
+	pub.Y.FillBytes(ret[1+size:])
+	return ret
+}
+
+// Compute the Z value of the Public Key, which binds the signer's identity
+// and the curve into the signed data.
+func (pub *PublicKey) Z(uid []byte) []byte {
+	params := pub.Params()
+	size := (params.BitSize + 7) / 8
+	a := new(big.Int).Sub(params.P, big.NewInt(3))
+
+	hash := sm3.New()
+	bits := len(uid) * 8
+	hash.Write([]byte{byte(bits >> 8), byte(bits)})
+	hash.Write(uid)
+	for _, n := range []*big.Int{a, params.B, params.Gx, params.Gy, pub.X, pub.Y} {
+		hash.Write(n.FillBytes(make([]byte, size)))
+	}
+	return hash.Sum(nil)
+}
+
+// Compute e, the SM3 hash of the Z value followed by the message.
+func (pub *PublicKey) hashMessage(msg []byte) *big.Int {
+	hash := sm3.New()
+	hash.Write(pub.Z(DefaultUID))
+	hash.Write(msg)
+	return new(big.Int).SetBytes(hash.Sum(nil))
+}
+
+// Report whether pub and x have the same value.
+func (pub *PublicKey) Equal(x crypto.PublicKey) bool {
+	other, ok := x.(*PublicKey)
+	if !ok {
+		return false
+	}
+	return pub.Curve == other.Curve &&
+		pub.X.Cmp(other.X) == 0 &&
+		pub.Y.Cmp(other.Y) == 0
+}
+
+// Return the Public Key, in order to implement crypto.Signer.
+func (priv *PrivateKey) Public() crypto.PublicKey {
+	return &priv.PublicKey
+}
+
+// Sign the message with the Private Key, using the DefaultUID. The opts are
+// ignored, since SM2 is always used with SM3.
+//
+// Even though crypto.Signer calls this a digest, SM2 treats it as the message,
+// and will hash it again along with the Z value. When used with ima.Sign, the
+// message is the SM3 digest of the file.
+func (priv *PrivateKey) Sign(random io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	n := priv.Params().N
+	e := priv.hashMessage(digest)
+
+	dInv := new(big.Int).Add(priv.D, one)
+	if dInv.ModInverse(dInv, n) == nil {
+		return nil, fmt.Errorf("sm2: invalid private key")
+	}
+
+	for {
+		k, err := rand.Int(random, new(big.Int).Sub(n, one))
+		if err != nil {
+			return nil, err
+		}
+		k.Add(k, one)
+
+		x1, _ := priv.ScalarBaseMult(k.Bytes())
+		r := new(big.Int).Add(e, x1)
+		r.Mod(r, n)
+		if r.Sign() == 0 || new(big.Int).Add(r, k).Cmp(n) == 0 {
+			continue
+		}
+
+		s := new(big.Int).Mul(r, priv.D)
+		s.Sub(k, s)
+		s.Mul(s, dInv)
+		s.Mod(s, n)
+		if s.Sign() == 0 {
+			continue
+		}
+
+		return asn1.Marshal(signature{R: r, S: s})
+	}
+}
+
+// Verify an ASN.1 DER encoded signature over the message with the Public Key,
+// using the DefaultUID.
+func VerifyASN1(pub *PublicKey, msg, sig []byte) bool {
+	parsed := signature{}
+	if rest, err := asn1.Unmarshal(sig, &parsed); err != nil || len(rest) != 0 {
+		return false
+	}
+	return Verify(pub, msg, parsed.R, parsed.S)
+}
+
+// Verify the signature (r, s) over the message with the Public Key, using
+// the DefaultUID.
+func Verify(pub *PublicKey, msg []byte, r, s *big.Int) bool {
+	n := pub.Params().N
+	if r.Sign() <= 0 || s.Sign() <= 0 || r.Cmp(n) >= 0 || s.Cmp(n) >= 0 {
+		return false
+	}
+	t := new(big.Int).Add(r, s)
+	t.Mod(t, n)
+	if t.Sign() == 0 {
+		return false
+	}
+
+	x1, y1 := pub.ScalarBaseMult(s.Bytes())
+	x2, y2 := pub.ScalarMult(pub.X, pub.Y, t.Bytes())
+	x, _ := pub.Add(x1, y1, x2, y2)
+
+	ret := new(big.Int).Add(pub.hashMessage(msg), x)
+	ret.Mod(ret, n)
+	return ret.Cmp(r) == 0
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sm2_test
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"encoding/hex"
+	"encoding/pem"
+
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+
+	"pault.ag/go/ima/sm2"
+	"pault.ag/go/ima/sm3"
+)
+
+func TestCurve(t *testing.T) {
+	params := sm2.P256().Params()
+	assert(t, sm2.P256().IsOnCurve(params.Gx, params.Gy))
+
+	// n * G is the point at infinity, which is (0, 0) in crypto/elliptic.
+	x, y := sm2.P256().ScalarBaseMult(params.N.Bytes())
+	assert(t, x.Sign() == 0 && y.Sign() == 0)
+}
+
+func TestSignVerify(t *testing.T) {
+	key, err := sm2.GenerateKey(rand.Reader)
+	isok(t, err)
+	assert(t, key.IsOnCurve(key.X, key.Y))
+
+	digest := sm3.Sum([]byte("Totally real ELF no tricks"))
+
+	sig, err := key.Sign(rand.Reader, digest[:], nil)
+	isok(t, err)
+	assert(t, sm2.VerifyASN1(&key.PublicKey, digest[:], sig))
+	assert(t, !sm2.VerifyASN1(&key.PublicKey, digest[1:], sig))
+
+	other, err := sm2.GenerateKey(rand.Reader)
+	isok(t, err)
+	assert(t, !sm2.VerifyASN1(&other.PublicKey, digest[:], sig))
+}
+
+// Known answer made with OpenSSL 3, using the DefaultUID, which
+// tjfoc/gmsm also verifies.
+func TestKnownAnswer(t *testing.T) {
+	d, _ := new(big.Int).SetString("7505d318bcd4b30e47cb7c4293a78e099148b9f04320d6467d630726f0ac0422", 16)
+	x, _ := new(big.Int).SetString("c40dafa83329b8e93ba6fe26421a8c13fe15b0e0527a97c34397b459dc814b13", 16)
+	y, _ := new(big.Int).SetString("3cae9361406a67deaa8d71a5ff2f8cbd1165e8f2570bda4d27edb6fe799873fd", 16)
+	sig, err := hex.DecodeString("304502201d720a9564578f038e6ebafb8610f24bf217fce2bd420977465403c21e13d0" +
+		"36022100d05b3ba1eb3cdbb103988cdede93a0f192bd454883ac5af5ec7b604b0f41a68e")
+	isok(t, err)
+	msg := []byte("totally legit elf af")
+
+	px, py := sm2.P256().ScalarBaseMult(d.Bytes())
+	assert(t, px.Cmp(x) == 0 && py.Cmp(y) == 0)
+
+	pub := &sm2.PublicKey{Curve: sm2.P256(), X: x, Y: y}
+	assert(t, sm2.VerifyASN1(pub, msg, sig))
+	assert(t, !sm2.VerifyASN1(pub, msg[1:], sig))
+
+	// And our own signature over it, with the same key.
+	priv := &sm2.PrivateKey{PublicKey: *pub, D: d}
+	sig, err = priv.Sign(rand.Reader, msg, nil)
+	isok(t, err)
+	assert(t, sm2.VerifyASN1(pub, msg, sig))
+}
+
+func TestPKIX(t *testing.T) {
+	// The public key from TestKnownAnswer, as OpenSSL encodes it.
+	block, _ := pem.Decode([]byte(`-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoEcz1UBgi0DQgAExA2vqDMpuOk7pv4mQhqME/4VsOBS
+epfDQ5e0WdyBSxM8rpNhQGpn3qqNcaX/L4y9EWXo8lcL2k0n7bb+eZhz/Q==
+-----END PUBLIC KEY-----
+`))
+	pub, err := sm2.ParsePKIXPublicKey(block.Bytes)
+	isok(t, err)
+	x, _ := new(big.Int).SetString("c40dafa83329b8e93ba6fe26421a8c13fe15b0e0527a97c34397b459dc814b13", 16)
+	assert(t, pub.X.Cmp(x) == 0)
+
+	der, err := sm2.MarshalPKIXPublicKey(pub)
+	isok(t, err)
+	assert(t, bytes.Equal(der, block.Bytes))
+
+	_, err = sm2.ParsePKIXPublicKey(append(der, 0))
+	notok(t, err)
+	der[len(der)-1] ^= 0xff
+	_, err = sm2.ParsePKIXPublicKey(der)
+	notok(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	isok(t, err)
+	der, err = x509.MarshalPKIXPublicKey(key.Public())
+	isok(t, err)
+	_, err = sm2.ParsePKIXPublicKey(der)
+	notok(t, err)
+}
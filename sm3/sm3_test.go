@@ -0,0 +1,53 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sm3_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"pault.ag/go/ima/sm3"
+)
+
+func TestVectors(t *testing.T) {
+	for _, vector := range []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  "abc",
+			output: "66c7f0f462eeedd9d1f2d46bdc10e4e24167c4875cf2f7a2297da02b8f4ba8e0",
+		},
+		{
+			input:  strings.Repeat("abcd", 16),
+			output: "debe9ff92275b8a138604889c18e5a4d6fdb70e5387e5765293dcba39c0c5732",
+		},
+	} {
+		expected, err := hex.DecodeString(vector.output)
+		isok(t, err)
+
+		sum := sm3.Sum([]byte(vector.input))
+		assert(t, bytes.Compare(sum[:], expected) == 0)
+
+		// Byte at a time, to make sure partial blocks are handled.
+		hash := sm3.New()
+		for _, b := range []byte(vector.input) {
+			hash.Write([]byte{b})
+		}
+		assert(t, bytes.Compare(hash.Sum(nil), expected) == 0)
+	}
+}
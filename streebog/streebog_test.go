@@ -0,0 +1,66 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package streebog_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"hash"
+	"testing"
+
+	"pault.ag/go/ima/streebog"
+)
+
+// Test vectors from RFC 6986, with the digests in the byte order they're
+// output in, rather than the big endian numbers printed in the RFC.
+var (
+	m1    = []byte("012345678901234567890123456789012345678901234567890123456789012")
+	m2, _ = hex.DecodeString(
+		"d1e520e2e5f2f0e82c20d1f2f0e8e1eee6e820e2edf3f6e82c20e2e5fef2fa20f120ecee" +
+			"f0ff20f1f2f0e5ebe0ece820ede020f5f0e0e1f0fbff20efebfaeafb20c8e3eef0e5e2fb",
+	)
+)
+
+func testVector(t *testing.T, newHash func() hash.Hash, input []byte, output string) {
+	expected, err := hex.DecodeString(output)
+	isok(t, err)
+
+	hash := newHash()
+	hash.Write(input)
+	assert(t, bytes.Compare(hash.Sum(nil), expected) == 0)
+
+	// Byte at a time, to make sure partial blocks are handled.
+	hash.Reset()
+	for _, b := range input {
+		hash.Write([]byte{b})
+	}
+	assert(t, bytes.Compare(hash.Sum(nil), expected) == 0)
+}
+
+func TestStreebog256(t *testing.T) {
+	testVector(t, streebog.New256, m1,
+		"9d151eefd8590b89daa6ba6cb74af9275dd051026bb149a452fd84e5e57b5500")
+	testVector(t, streebog.New256, m2,
+		"9dd2fe4e90409e5da87f53976d7405b0c0cac628fc669a741d50063c557e8f50")
+}
+
+func TestStreebog512(t *testing.T) {
+	testVector(t, streebog.New512, m1,
+		"1b54d01a4af5b9d5cc3d86d68d285462b19abc2475222f35c085122be4ba1ffa"+
+			"00ad30f8767b3a82384c6574f024c311e2a481332b08ef7f41797891c1646f48")
+	testVector(t, streebog.New512, m2,
+		"1e88e62226bfca6f9994f1f2d51569e0daf8475a3b0fe61a5300eee46d961376"+
+			"035fe83549ada2b8620fcd7c496ce5b33f0cb9dddc2b6460143b03dabac9fb28")
+}
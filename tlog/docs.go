@@ -0,0 +1,26 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// An append-only transparency log of IMA signatures, so that every
+// signature a key has made can be audited.
+//
+// The log is a Merkle tree, hashed as in RFC 6962, over the Entries in a log
+// file. An inclusion proof shows that an Entry is in the log, and a
+// consistency proof shows that a later version of the log is the earlier one
+// with only new Entries appended. A monitor that remembers the Root of the
+// log can use consistency proofs to catch Entries being changed or removed.
+//
+// The log file is JSON, one Entry per line, and each leaf of the tree is the
+// bytes of a line, without the newline.
+package tlog
@@ -0,0 +1,410 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"encoding/hex"
+	"encoding/json"
+
+	"crypto"
+
+	"golang.org/x/sys/unix"
+
+	"pault.ag/go/ima"
+)
+
+var (
+	// Returned by Find when the signature isn't in the log.
+	NotLogged error = fmt.Errorf("tlog: signature is not in the log")
+
+	// Returned by Read and Load when the last line of the log is missing
+	// its newline, which is what's left if a process crashes while
+	// appending. Open truncates the line away instead.
+	IncompleteEntry error = fmt.Errorf("tlog: last entry is incomplete")
+)
+
+// Record of a single IMA signature.
+type Entry struct {
+	// When the signature was made.
+	Time time.Time
+
+	// Key ID from the signature header.
+	KeyId [4]byte
+
+	// Hash algorithm the file was measured with.
+	Hash ima.Hash
+
+	// Digest of the file that was signed.
+	Digest []byte
+
+	// Serialized signature, as it would be stored in the security.ima
+	// xattr.
+	Signature []byte
+
+	// Path of the file that was signed, if it's known.
+	Path string
+}
+
+// Create an Entry for a serialized signature, such as the output of ima.Sign,
+// over the digest of the file at path. The Time is left unset, for Append to
+// fill in.
+func NewEntry(value, digest []byte, path string) (*Entry, error) {
+	parsed, err := ima.ParseXattr(value)
+	if err != nil {
+		return nil, err
+	}
+	sig, ok := parsed.(*ima.Signature)
+	if !ok {
+		return nil, fmt.Errorf("tlog: only version 2 and 3 signatures can be logged")
+	}
+	imaHash, err := sig.Header.Algorithm()
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{
+		KeyId:     sig.Header.KeyID,
+		Hash:      *imaHash,
+		Digest:    append([]byte{}, digest...),
+		Signature: append([]byte{}, value...),
+		Path:      path,
+	}, nil
+}
+
+// How an Entry is written to the log file.
+type jsonEntry struct {
+	Time      time.Time `json:"time"`
+	KeyId     string    `json:"keyid"`
+	Hash      string    `json:"hash"`
+	Digest    string    `json:"digest"`
+	Signature string    `json:"signature"`
+	Path      string    `json:"path,omitempty"`
+}
+
+func (e Entry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonEntry{
+		Time:      e.Time,
+		KeyId:     hex.EncodeToString(e.KeyId[:]),
+		Hash:      e.Hash.Name,
+		Digest:    hex.EncodeToString(e.Digest),
+		Signature: hex.EncodeToString(e.Signature),
+		Path:      e.Path,
+	})
+}
+
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	raw := jsonEntry{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	imaHash, err := ima.HashFunctions.ByName(raw.Hash)
+	if err != nil {
+		return err
+	}
+	keyId, err := hex.DecodeString(raw.KeyId)
+	if err != nil || len(keyId) != 4 {
+		return fmt.Errorf("tlog: bad keyid %q", raw.KeyId)
+	}
+	digest, err := hex.DecodeString(raw.Digest)
+	if err != nil {
+		return fmt.Errorf("tlog: bad digest: %s", err)
+	}
+	signature, err := hex.DecodeString(raw.Signature)
+	if err != nil {
+		return fmt.Errorf("tlog: bad signature: %s", err)
+	}
+
+	*e = Entry{
+		Time:      raw.Time,
+		Hash:      *imaHash,
+		Digest:    digest,
+		Signature: signature,
+		Path:      raw.Path,
+	}
+	copy(e.KeyId[:], keyId)
+	return nil
+}
+
+// Append-only log of Entries. A Log is safe for concurrent use. Any number
+// of processes may have the same log file Open; the file is locked while an
+// Entry is appended, and Entries appended by anyone else are read in first.
+type Log struct {
+	lock    sync.RWMutex
+	fd      *os.File
+	offset  int64
+	entries []Entry
+	leaves  []NodeHash
+}
+
+// Open the log file at path for appending, creating it if it doesn't exist.
+// The Entries already in the file are read, so that proofs can be made over
+// them. If the last Entry was only partly written, it's truncated away.
+func Open(path string) (*Log, error) {
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	log := &Log{fd: fd}
+	if err := log.withFileLock(log.readNew); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return log, nil
+}
+
+// Load the log file at path, without opening it for appending.
+func Load(path string) (*Log, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return Read(fd)
+}
+
+// Read a log from r. The Log can't be appended to.
+func Read(r io.Reader) (*Log, error) {
+	log := &Log{}
+	if err := log.read(r); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// Read Entries from r, and add them to the Log. If the last line is missing
+// its newline, every Entry before it is added, and IncompleteEntry is
+// returned.
+func (l *Log) read(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) != 0 {
+				return fmt.Errorf("%w (entry %d)", IncompleteEntry, len(l.entries))
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		size := int64(len(line))
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		entry := Entry{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("tlog: entry %d: %s", len(l.entries), err)
+		}
+		l.entries = append(l.entries, entry)
+		l.leaves = append(l.leaves, LeafHash(line))
+		l.offset += size
+	}
+}
+
+// Read any Entries that were appended to the log file since it was last
+// read, and truncate a partly written last Entry. This must be called with
+// the file locked.
+func (l *Log) readNew() error {
+	info, err := l.fd.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < l.offset {
+		return fmt.Errorf("tlog: log file is shorter than the entries read from it")
+	}
+	err = l.read(io.NewSectionReader(l.fd, l.offset, info.Size()-l.offset))
+	if errors.Is(err, IncompleteEntry) {
+		// Whoever was writing it is gone, or they'd be holding the lock.
+		if err := l.fd.Truncate(l.offset); err != nil {
+			return err
+		}
+		return l.fd.Sync()
+	}
+	return err
+}
+
+// Call fn with an exclusive lock held on the log file, so that no other
+// process can append to it at the same time.
+func (l *Log) withFileLock(fn func() error) error {
+	fd := int(l.fd.Fd())
+	if err := unix.Flock(fd, unix.LOCK_EX); err != nil {
+		return fmt.Errorf("tlog: locking the log file: %w", err)
+	}
+	defer unix.Flock(fd, unix.LOCK_UN)
+	return fn()
+}
+
+// Close the log file, if it's open.
+func (l *Log) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.fd == nil {
+		return nil
+	}
+	err := l.fd.Close()
+	l.fd = nil
+	return err
+}
+
+// Append an Entry to the log, and return its index. If the Entry's Time
+// isn't set, it will be set to the current time. The Entry is synced to
+// disk before Append returns.
+func (l *Log) Append(entry Entry) (uint64, error) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.fd == nil {
+		return 0, fmt.Errorf("tlog: log is not open for appending")
+	}
+	err = l.withFileLock(func() error {
+		if err := l.readNew(); err != nil {
+			return err
+		}
+		if _, err := l.fd.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		return l.fd.Sync()
+	})
+	if err != nil {
+		return 0, err
+	}
+	l.entries = append(l.entries, entry)
+	l.leaves = append(l.leaves, LeafHash(line))
+	l.offset += int64(len(line) + 1)
+	return uint64(len(l.entries) - 1), nil
+}
+
+// Sign the digest of the file at path with ima.Sign, and Append an Entry for
+// the signature to the log. If the signature can't be logged, it isn't
+// returned.
+func (l *Log) Sign(signer crypto.Signer, rand io.Reader, digest []byte, opts crypto.SignerOpts, path string) ([]byte, error) {
+	sig, err := ima.Sign(signer, rand, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := NewEntry(sig, digest, path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := l.Append(*entry); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// Return the number of Entries in the log.
+func (l *Log) Size() uint64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return uint64(len(l.entries))
+}
+
+// Return the Entry at index.
+func (l *Log) Entry(index uint64) (Entry, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if index >= uint64(len(l.entries)) {
+		return Entry{}, fmt.Errorf("tlog: entry %d is not in a log of size %d", index, len(l.entries))
+	}
+	return l.entries[index], nil
+}
+
+// Return the hash of the leaf for the Entry at index.
+func (l *Log) LeafHash(index uint64) (NodeHash, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if index >= uint64(len(l.leaves)) {
+		return NodeHash{}, fmt.Errorf("tlog: entry %d is not in a log of size %d", index, len(l.leaves))
+	}
+	return l.leaves[index], nil
+}
+
+// Get the leaves of the first size Entries in the log.
+func (l *Log) prefix(size uint64) ([]NodeHash, error) {
+	if size > uint64(len(l.leaves)) {
+		return nil, fmt.Errorf("tlog: log has %d entries, not %d", len(l.leaves), size)
+	}
+	return l.leaves[:size], nil
+}
+
+// Return the current size and Root of the log.
+func (l *Log) Root() (uint64, NodeHash) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return uint64(len(l.leaves)), RootHash(l.leaves)
+}
+
+// Return the Root of the log as it was when it had size Entries.
+func (l *Log) RootAt(size uint64) (NodeHash, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	leaves, err := l.prefix(size)
+	if err != nil {
+		return NodeHash{}, err
+	}
+	return RootHash(leaves), nil
+}
+
+// Prove that the Entry at index is in the log as it was when it had size
+// Entries. Check the proof with VerifyInclusion.
+func (l *Log) InclusionProof(index, size uint64) ([]NodeHash, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	leaves, err := l.prefix(size)
+	if err != nil {
+		return nil, err
+	}
+	return InclusionProof(leaves, index)
+}
+
+// Prove that the log as it was when it had size Entries only has Entries
+// appended to it since it had oldSize Entries. Check the proof with
+// VerifyConsistency.
+func (l *Log) ConsistencyProof(oldSize, size uint64) ([]NodeHash, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	leaves, err := l.prefix(size)
+	if err != nil {
+		return nil, err
+	}
+	return ConsistencyProof(leaves, oldSize)
+}
+
+// Find the first Entry for a serialized signature, such as the value of a
+// security.ima xattr, and return its index. If the signature isn't in the
+// log, NotLogged will be returned.
+func (l *Log) Find(value []byte) (uint64, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	for i, entry := range l.entries {
+		if bytes.Equal(entry.Signature, value) {
+			return uint64(i), nil
+		}
+	}
+	return 0, NotLogged
+}
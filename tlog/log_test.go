@@ -0,0 +1,203 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlog_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+
+	"pault.ag/go/ima"
+	"pault.ag/go/ima/tlog"
+)
+
+func TestLog(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	dir, err := ioutil.TempDir("", "ima-tlog")
+	isok(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+
+	log, err := tlog.Open(path)
+	isok(t, err)
+	sigs := [][]byte{}
+	for _, name := range []string{"/bin/true", "/bin/false", "/bin/sh"} {
+		digest := sha256.Sum256([]byte(name))
+		sig, err := log.Sign(key, rand.Reader, digest[:], crypto.SHA256, name)
+		isok(t, err)
+		sigs = append(sigs, sig)
+	}
+	oldSize, oldRoot := log.Root()
+	assert(t, oldSize == 3)
+	isok(t, log.Close())
+
+	// Reopen the log, and keep appending.
+	log, err = tlog.Open(path)
+	isok(t, err)
+	defer log.Close()
+	assert(t, log.Size() == 3)
+	root, err := log.RootAt(3)
+	isok(t, err)
+	assert(t, root == oldRoot)
+
+	digest := sha256.Sum256([]byte("/bin/ls"))
+	_, err = log.Sign(key, rand.Reader, digest[:], crypto.SHA256, "/bin/ls")
+	isok(t, err)
+	size, root := log.Root()
+	assert(t, size == 4)
+
+	index, err := log.Find(sigs[1])
+	isok(t, err)
+	assert(t, index == 1)
+	entry, err := log.Entry(index)
+	isok(t, err)
+	assert(t, entry.Path == "/bin/false")
+	assert(t, entry.Hash.Id == ima.SHA256.Id)
+	assert(t, !entry.Time.IsZero())
+	id, err := ima.PublicKeyId(key.Public())
+	isok(t, err)
+	assert(t, entry.KeyId == id)
+
+	leaf, err := log.LeafHash(index)
+	isok(t, err)
+	proof, err := log.InclusionProof(index, size)
+	isok(t, err)
+	isok(t, tlog.VerifyInclusion(leaf, index, size, proof, root))
+
+	proof, err = log.ConsistencyProof(oldSize, size)
+	isok(t, err)
+	isok(t, tlog.VerifyConsistency(oldSize, size, proof, oldRoot, root))
+
+	_, err = log.Find([]byte{0x03, 0x02})
+	assert(t, err == tlog.NotLogged)
+}
+
+func TestLogReadOnly(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	dir, err := ioutil.TempDir("", "ima-tlog")
+	isok(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+
+	log, err := tlog.Open(path)
+	isok(t, err)
+	digest := sha256.Sum256([]byte("/bin/true"))
+	_, err = log.Sign(key, rand.Reader, digest[:], crypto.SHA256, "")
+	isok(t, err)
+	isok(t, log.Close())
+
+	loaded, err := tlog.Load(path)
+	isok(t, err)
+	assert(t, loaded.Size() == 1)
+	_, err = loaded.Sign(key, rand.Reader, digest[:], crypto.SHA256, "")
+	notok(t, err)
+	assert(t, loaded.Size() == 1)
+
+	// A half written entry isn't a valid log.
+	data, err := ioutil.ReadFile(path)
+	isok(t, err)
+	_, err = tlog.Read(bytes.NewReader(append(data, data[:10]...)))
+	assert(t, errors.Is(err, tlog.IncompleteEntry))
+	_, err = tlog.Read(bytes.NewReader(append(data, []byte("{}\n")...)))
+	notok(t, err)
+}
+
+func TestLogIncompleteEntry(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	dir, err := ioutil.TempDir("", "ima-tlog")
+	isok(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+
+	log, err := tlog.Open(path)
+	isok(t, err)
+	digest := sha256.Sum256([]byte("/bin/true"))
+	_, err = log.Sign(key, rand.Reader, digest[:], crypto.SHA256, "/bin/true")
+	isok(t, err)
+	isok(t, log.Close())
+
+	// Crash halfway through writing the next entry.
+	data, err := ioutil.ReadFile(path)
+	isok(t, err)
+	isok(t, ioutil.WriteFile(path, append(data, data[:10]...), 0644))
+
+	_, err = tlog.Load(path)
+	assert(t, errors.Is(err, tlog.IncompleteEntry))
+
+	log, err = tlog.Open(path)
+	isok(t, err)
+	defer log.Close()
+	assert(t, log.Size() == 1)
+	truncated, err := ioutil.ReadFile(path)
+	isok(t, err)
+	assert(t, bytes.Equal(truncated, data))
+
+	digest = sha256.Sum256([]byte("/bin/false"))
+	_, err = log.Sign(key, rand.Reader, digest[:], crypto.SHA256, "/bin/false")
+	isok(t, err)
+	loaded, err := tlog.Load(path)
+	isok(t, err)
+	assert(t, loaded.Size() == 2)
+}
+
+func TestLogSharedAppend(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	dir, err := ioutil.TempDir("", "ima-tlog")
+	isok(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+
+	first, err := tlog.Open(path)
+	isok(t, err)
+	defer first.Close()
+	second, err := tlog.Open(path)
+	isok(t, err)
+	defer second.Close()
+
+	// Each Log picks up what the other appended before adding its own.
+	for i, name := range []string{"/bin/true", "/bin/false", "/bin/sh", "/bin/ls"} {
+		log := first
+		if i%2 == 1 {
+			log = second
+		}
+		digest := sha256.Sum256([]byte(name))
+		_, err = log.Sign(key, rand.Reader, digest[:], crypto.SHA256, name)
+		isok(t, err)
+		assert(t, log.Size() == uint64(i+1))
+	}
+
+	loaded, err := tlog.Load(path)
+	isok(t, err)
+	size, root := loaded.Root()
+	assert(t, size == 4)
+	_, secondRoot := second.Root()
+	assert(t, secondRoot == root)
+
+	entry, err := second.Entry(2)
+	isok(t, err)
+	assert(t, entry.Path == "/bin/sh")
+}
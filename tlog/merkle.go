@@ -0,0 +1,203 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlog
+
+import (
+	"fmt"
+
+	"crypto/sha256"
+)
+
+var (
+	// Returned when an inclusion or consistency proof doesn't check out.
+	InvalidProof error = fmt.Errorf("tlog: invalid proof")
+)
+
+// Hash of a leaf or interior node of the Merkle tree.
+type NodeHash [sha256.Size]byte
+
+// Compute the hash of a leaf of the Merkle tree, which is the SHA256 of a
+// zero byte followed by the leaf data.
+func LeafHash(data []byte) NodeHash {
+	return sha256.Sum256(append([]byte{0x00}, data...))
+}
+
+// Compute the hash of an interior node of the Merkle tree, which is the
+// SHA256 of a one byte followed by the hashes of its children.
+func nodeHash(left, right NodeHash) NodeHash {
+	data := make([]byte, 0, 1+2*sha256.Size)
+	data = append(data, 0x01)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+	return sha256.Sum256(data)
+}
+
+// Largest power of two smaller than n, which is where the tree is split.
+func split(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// Compute the Root of a Merkle tree over the leaf hashes. The Root of an
+// empty tree is the SHA256 of nothing.
+func RootHash(leaves []NodeHash) NodeHash {
+	switch len(leaves) {
+	case 0:
+		return sha256.Sum256(nil)
+	case 1:
+		return leaves[0]
+	}
+	k := split(uint64(len(leaves)))
+	return nodeHash(RootHash(leaves[:k]), RootHash(leaves[k:]))
+}
+
+// Compute the proof that the leaf at index is in the Merkle tree over the
+// leaf hashes, which is the audit path of RFC 6962 section 2.1.1.
+func InclusionProof(leaves []NodeHash, index uint64) ([]NodeHash, error) {
+	if index >= uint64(len(leaves)) {
+		return nil, fmt.Errorf("tlog: leaf %d is not in a tree of size %d", index, len(leaves))
+	}
+	return inclusionProof(leaves, index), nil
+}
+
+func inclusionProof(leaves []NodeHash, index uint64) []NodeHash {
+	if len(leaves) <= 1 {
+		return []NodeHash{}
+	}
+	k := split(uint64(len(leaves)))
+	if index < k {
+		return append(inclusionProof(leaves[:k], index), RootHash(leaves[k:]))
+	}
+	return append(inclusionProof(leaves[k:], index-k), RootHash(leaves[:k]))
+}
+
+// Compute the proof that the Merkle tree over the first size leaf hashes is
+// a prefix of the tree over all of them, which is the consistency proof of
+// RFC 6962 section 2.1.2.
+func ConsistencyProof(leaves []NodeHash, size uint64) ([]NodeHash, error) {
+	if size > uint64(len(leaves)) {
+		return nil, fmt.Errorf("tlog: a tree of size %d can't be consistent with one of size %d", len(leaves), size)
+	}
+	if size == 0 || size == uint64(len(leaves)) {
+		return []NodeHash{}, nil
+	}
+	return consistencyProof(leaves, size, true), nil
+}
+
+func consistencyProof(leaves []NodeHash, size uint64, complete bool) []NodeHash {
+	n := uint64(len(leaves))
+	if size == n {
+		if complete {
+			return []NodeHash{}
+		}
+		return []NodeHash{RootHash(leaves)}
+	}
+	k := split(n)
+	if size <= k {
+		return append(consistencyProof(leaves[:k], size, complete), RootHash(leaves[k:]))
+	}
+	return append(consistencyProof(leaves[k:], size-k, false), RootHash(leaves[:k]))
+}
+
+// Check that the leaf hash is at index in the Merkle tree of size with the
+// given Root, using the algorithm of RFC 9162 section 2.1.3.2. If it isn't,
+// InvalidProof will be returned.
+func VerifyInclusion(leaf NodeHash, index, size uint64, proof []NodeHash, root NodeHash) error {
+	if index >= size {
+		return InvalidProof
+	}
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return InvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || r != root {
+		return InvalidProof
+	}
+	return nil
+}
+
+// Check that the Merkle tree of size with the given Root is the tree of
+// oldSize with oldRoot, with only leaves appended, using the algorithm of RFC
+// 9162 section 2.1.4.2. If it isn't, InvalidProof will be returned.
+func VerifyConsistency(oldSize, size uint64, proof []NodeHash, oldRoot, root NodeHash) error {
+	switch {
+	case oldSize > size:
+		return InvalidProof
+	case oldSize == size:
+		if len(proof) != 0 || oldRoot != root {
+			return InvalidProof
+		}
+		return nil
+	case oldSize == 0:
+		// Every tree is an extension of the empty tree.
+		if len(proof) != 0 {
+			return InvalidProof
+		}
+		return nil
+	}
+
+	if oldSize&(oldSize-1) == 0 {
+		// The old tree is a complete subtree, so its Root is left out.
+		proof = append([]NodeHash{oldRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return InvalidProof
+	}
+
+	fn, sn := oldSize-1, size-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return InvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || fr != oldRoot || sr != root {
+		return InvalidProof
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlog_test
+
+import (
+	"testing"
+
+	"encoding/hex"
+
+	"pault.ag/go/ima/tlog"
+)
+
+// Leaves from the RFC 6962 reference test data.
+var testLeaves = []string{
+	"",
+	"00",
+	"10",
+	"2021",
+	"3031",
+	"40414243",
+	"5051525354555657",
+	"606162636465666768696a6b6c6d6e6f",
+}
+
+func leafHashes(t *testing.T, n int) []tlog.NodeHash {
+	ret := []tlog.NodeHash{}
+	for i := 0; i < n; i++ {
+		data, err := hex.DecodeString(testLeaves[i%len(testLeaves)])
+		isok(t, err)
+		ret = append(ret, tlog.LeafHash(append(data, byte(i/len(testLeaves)))))
+	}
+	return ret
+}
+
+func TestRootHash(t *testing.T) {
+	leaves := []tlog.NodeHash{}
+	for _, leaf := range testLeaves {
+		data, err := hex.DecodeString(leaf)
+		isok(t, err)
+		leaves = append(leaves, tlog.LeafHash(data))
+	}
+
+	for size, expected := range map[int]string{
+		0: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		1: "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		8: "5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+	} {
+		root := tlog.RootHash(leaves[:size])
+		assert(t, hex.EncodeToString(root[:]) == expected)
+	}
+}
+
+func TestInclusionProof(t *testing.T) {
+	leaves := leafHashes(t, 17)
+	for size := uint64(1); size <= uint64(len(leaves)); size++ {
+		root := tlog.RootHash(leaves[:size])
+		for index := uint64(0); index < size; index++ {
+			proof, err := tlog.InclusionProof(leaves[:size], index)
+			isok(t, err)
+			isok(t, tlog.VerifyInclusion(leaves[index], index, size, proof, root))
+
+			// The wrong leaf, index or root shouldn't check out.
+			notok(t, tlog.VerifyInclusion(leaves[index], index+1, size, proof, root))
+			if size > 1 {
+				notok(t, tlog.VerifyInclusion(leaves[(index+1)%size], index, size, proof, root))
+				notok(t, tlog.VerifyInclusion(leaves[index], index, size, proof, leaves[index]))
+			}
+		}
+	}
+
+	_, err := tlog.InclusionProof(leaves, uint64(len(leaves)))
+	notok(t, err)
+}
+
+func TestConsistencyProof(t *testing.T) {
+	leaves := leafHashes(t, 17)
+	for size := uint64(0); size <= uint64(len(leaves)); size++ {
+		root := tlog.RootHash(leaves[:size])
+		for oldSize := uint64(0); oldSize <= size; oldSize++ {
+			oldRoot := tlog.RootHash(leaves[:oldSize])
+			proof, err := tlog.ConsistencyProof(leaves[:size], oldSize)
+			isok(t, err)
+			isok(t, tlog.VerifyConsistency(oldSize, size, proof, oldRoot, root))
+
+			if oldSize == 0 || oldSize == size {
+				continue
+			}
+
+			// A log with a changed entry isn't consistent.
+			changed := append([]tlog.NodeHash{}, leaves[:size]...)
+			changed[oldSize-1] = tlog.LeafHash([]byte("evil"))
+			proof, err = tlog.ConsistencyProof(changed, oldSize)
+			isok(t, err)
+			notok(t, tlog.VerifyConsistency(oldSize, size, proof, oldRoot, tlog.RootHash(changed)))
+		}
+	}
+
+	_, err := tlog.ConsistencyProof(leaves[:3], 4)
+	notok(t, err)
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ima
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"crypto/x509"
+)
+
+// This is returned, wrapped with the reason, when a key is refused by a
+// KeyPool that is restricted to TrustAnchors.
+var UntrustedKey error = fmt.Errorf("ima: key is not trusted")
+
+// TrustAnchors are the CA certificates that are allowed to vouch for IMA
+// keys, much like the kernel's .builtin_trusted_keys and
+// .secondary_trusted_keys keyrings are for the .ima keyring.
+//
+// TrustAnchors are safe for concurrent use.
+type TrustAnchors struct {
+	lock    sync.RWMutex
+	anchors []*x509.Certificate
+}
+
+// Create a new set of TrustAnchors from the provided root certificates,
+// which must all be CAs. Roots are trusted as-is, without checking who
+// signed them, just like the kernel's builtin keys.
+func NewTrustAnchors(roots ...*x509.Certificate) (*TrustAnchors, error) {
+	anchors := &TrustAnchors{}
+	for _, root := range roots {
+		if err := anchors.AddRoot(root); err != nil {
+			return nil, err
+		}
+	}
+	return anchors, nil
+}
+
+// Add a root certificate, which is trusted without being checked against
+// any other TrustAnchor. It must be a CA.
+func (t *TrustAnchors) AddRoot(root *x509.Certificate) error {
+	if !root.BasicConstraintsValid || !root.IsCA {
+		return fmt.Errorf("ima: trust anchor %q is not a CA", root.Subject)
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.anchors = append(t.anchors, root)
+	return nil
+}
+
+// Add an intermediate CA certificate, which must itself be issued by one
+// of the TrustAnchors. This mirrors how keys are added to the kernel's
+// .secondary_trusted_keys keyring.
+func (t *TrustAnchors) AddIntermediate(cert *x509.Certificate) error {
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return fmt.Errorf("ima: trust anchor %q is not a CA", cert.Subject)
+	}
+	if _, err := t.issuer(cert); err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.anchors = append(t.anchors, cert)
+	return nil
+}
+
+// Load root certificates from files, directories or globs. See
+// KeyPool.Load for details; any bare public keys are reported as errors.
+func (t *TrustAnchors) Load(paths ...string) error {
+	return loadFiles(paths, func(path string) error {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		keys, err := parseKeys(data)
+		if err != nil {
+			return err
+		}
+		certs := []*x509.Certificate{}
+		for _, key := range keys {
+			cert, ok := key.(*x509.Certificate)
+			if !ok {
+				return fmt.Errorf("trust anchors must be certificates")
+			}
+			certs = append(certs, cert)
+		}
+		for _, cert := range certs {
+			if err := t.AddRoot(cert); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Check that the certificate is allowed to hold an IMA key: it has to be
+// signed by one of the TrustAnchors, and its keyUsage has to allow
+// digitalSignature. The error returned wraps UntrustedKey, and says which
+// check failed.
+func (t *TrustAnchors) Check(cert *x509.Certificate) error {
+	if cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return fmt.Errorf("%w: certificate %q keyUsage does not allow digitalSignature", UntrustedKey, cert.Subject)
+	}
+	_, err := t.issuer(cert)
+	return err
+}
+
+// Find the TrustAnchor that signed the certificate.
+func (t *TrustAnchors) issuer(cert *x509.Certificate) (*x509.Certificate, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	var lastErr error
+	for _, anchor := range t.anchors {
+		if !bytes.Equal(cert.RawIssuer, anchor.RawSubject) {
+			continue
+		}
+		// CheckSignatureFrom also enforces the anchor's basicConstraints
+		// and keyCertSign keyUsage.
+		if err := cert.CheckSignatureFrom(anchor); err != nil {
+			lastErr = err
+			continue
+		}
+		return anchor, nil
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("%w: certificate %q: %s", UntrustedKey, cert.Subject, lastErr)
+	}
+	return nil, fmt.Errorf("%w: certificate %q is not issued by a trust anchor", UntrustedKey, cert.Subject)
+}
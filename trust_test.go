@@ -0,0 +1,88 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ima_test
+
+import (
+	"errors"
+	"testing"
+
+	"crypto/x509"
+	"crypto/x509/pkix"
+
+	"pault.ag/go/ima"
+)
+
+func TestTrustedKeyPool(t *testing.T) {
+	root := newTestCA(t, nil, "root")
+	other := newTestCA(t, nil, "other")
+	intermediate := newTestCA(t, root, "intermediate")
+
+	good := newTestCert(t, root, x509.Certificate{
+		Subject:  pkix.Name{CommonName: "good"},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	})
+	viaIntermediate := newTestCert(t, intermediate, x509.Certificate{
+		Subject:  pkix.Name{CommonName: "intermediate leaf"},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	})
+	noUsage := newTestCert(t, root, x509.Certificate{
+		Subject:  pkix.Name{CommonName: "no usage"},
+		KeyUsage: x509.KeyUsageKeyEncipherment,
+	})
+	untrusted := newTestCert(t, other, x509.Certificate{
+		Subject:  pkix.Name{CommonName: "untrusted"},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	})
+	notCA := newTestCert(t, root, x509.Certificate{
+		Subject:               pkix.Name{CommonName: "not a ca"},
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	})
+	fromNotCA := newTestCert(t, notCA, x509.Certificate{
+		Subject:  pkix.Name{CommonName: "from not a ca"},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	})
+
+	_, err := ima.NewTrustAnchors(notCA.cert)
+	notok(t, err)
+
+	anchors, err := ima.NewTrustAnchors(root.cert)
+	isok(t, err)
+	pool := ima.NewTrustedKeyPool(anchors)
+
+	isok(t, pool.AddCertificate(good.cert))
+
+	err = pool.AddCertificate(viaIntermediate.cert)
+	assert(t, errors.Is(err, ima.UntrustedKey))
+	isok(t, anchors.AddIntermediate(intermediate.cert))
+	isok(t, pool.AddCertificate(viaIntermediate.cert))
+
+	for _, cert := range []*x509.Certificate{noUsage.cert, untrusted.cert, fromNotCA.cert} {
+		err := pool.AddCertificate(cert)
+		notok(t, err)
+		assert(t, errors.Is(err, ima.UntrustedKey))
+	}
+	notok(t, anchors.AddIntermediate(other.cert))
+
+	err = pool.AddKey(good.key.Public())
+	assert(t, errors.Is(err, ima.UntrustedKey))
+
+	assert(t, pool.Len() == 2)
+	assert(t, pool.MaybeContains(good.key.Public()))
+	assert(t, !pool.MaybeContains(untrusted.key.Public()))
+
+	clone := pool.Clone()
+	notok(t, clone.AddCertificate(untrusted.cert))
+}
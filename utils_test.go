@@ -17,7 +17,16 @@ package ima_test
 import (
 	"io"
 	"log"
+	"math/big"
 	"testing"
+	"time"
+
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 )
 
 func isok(t *testing.T, err error) {
@@ -40,3 +49,52 @@ func assert(t *testing.T, expr bool) {
 		t.FailNow()
 	}
 }
+
+// Key and Certificate made by newTestCert.
+type testCert struct {
+	key  crypto.Signer
+	cert *x509.Certificate
+}
+
+var testSerial int64
+
+// Issue a Certificate from the template for a new P-256 key, signed by the
+// issuer, or self-signed if the issuer is nil.
+func newTestCert(t *testing.T, issuer *testCert, template x509.Certificate) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	isok(t, err)
+	return newTestKeyCert(t, issuer, key, template)
+}
+
+// Issue a Certificate from the template for key, the same way as
+// newTestCert. The serial number, and unset validity period, are filled in.
+func newTestKeyCert(t *testing.T, issuer *testCert, key crypto.Signer, template x509.Certificate) *testCert {
+	testSerial++
+	template.SerialNumber = big.NewInt(testSerial)
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(time.Hour)
+	}
+
+	parent, signer := &template, key
+	if issuer != nil {
+		parent, signer = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, parent, key.Public(), signer)
+	isok(t, err)
+	cert, err := x509.ParseCertificate(der)
+	isok(t, err)
+	return &testCert{key: key, cert: cert}
+}
+
+// Issue a CA Certificate named name, the same way as newTestCert.
+func newTestCA(t *testing.T, issuer *testCert, name string) *testCert {
+	return newTestCert(t, issuer, x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	})
+}
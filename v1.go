@@ -0,0 +1,268 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ima
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"encoding/binary"
+
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+)
+
+// Header of a legacy version 1 IMA Signature, as written by early versions
+// of evmctl. This is laid out quite differently from the SignatureHeader,
+// and carries an 8 byte Key ID, which is computed differently from the 4 byte
+// Key ID of later versions. See PublicKeyIdV1.
+type SignatureV1Header struct {
+	// Always 0x03 (XattrDigSig).
+	Magic uint8
+
+	// Always 0x01.
+	Version uint8
+
+	// Time the signature was created. This is not used during
+	// verification, other than being part of the signed data.
+	Timestamp uint32
+
+	// Public key algorithm. The only defined algorithm is 0x00, RSA.
+	PublicKeyAlgorithm uint8
+
+	// Hash algorithm used to hash the digest and header. Unlike later
+	// versions this is not an IMA EVM Hash ID; it's either 0x00 for SHA1, or
+	// 0x01 for SHA256.
+	HashAlgorithm uint8
+
+	// Last 8 bytes of a SHA1 hash over the evmctl encoding of the RSA
+	// Public Key.
+	KeyID [8]byte
+
+	// Number of MPIs following the header, which should always be 1.
+	MPICount uint8
+}
+
+// Legacy version 1 IMA Signature. These can be parsed and verified, but this
+// library won't create new ones. Please use version 2 or 3 Signatures
+// instead.
+type SignatureV1 struct {
+	Header SignatureV1Header
+
+	// RSA signature, decoded from the MPI that follows the header.
+	Signature []byte
+}
+
+// Return the xattr type byte of the Signature, which is the Magic in the
+// Signature Header.
+func (s SignatureV1) XattrType() uint8 {
+	return s.Header.Magic
+}
+
+// Get the native Go crypto.Hash used to compute the data the signature is
+// made over. This is not the same as the algorithm used to compute the file
+// digest, which isn't recorded in a version 1 signature.
+func (h SignatureV1Header) Hash() (*crypto.Hash, error) {
+	var hash crypto.Hash
+	switch h.HashAlgorithm {
+	case 0x00:
+		hash = crypto.SHA1
+	case 0x01:
+		hash = crypto.SHA256
+	default:
+		return nil, fmt.Errorf("%w: unknown version 1 hash algorithm %x", UnsupportedAlgorithm, h.HashAlgorithm)
+	}
+	return &hash, nil
+}
+
+// Take a byte array and return a new SignatureV1 object, containing the
+// parsed headers and Signature.
+func ParseV1(signature []byte) (*SignatureV1, error) {
+	data := bytes.NewReader(signature)
+	line := SignatureV1Header{}
+
+	if err := binary.Read(data, binary.BigEndian, &line); err != nil {
+		return nil, err
+	}
+	if line.Magic != XattrDigSig || line.Version != SignatureVersion1 {
+		return nil, fmt.Errorf("ima: input data is in a bad format")
+	}
+	if line.PublicKeyAlgorithm != 0x00 {
+		return nil, fmt.Errorf("ima: version 1 signature is not RSA")
+	}
+	if line.MPICount != 1 {
+		return nil, fmt.Errorf("ima: expected 1 MPI, got %d", line.MPICount)
+	}
+
+	var bits uint16
+	if err := binary.Read(data, binary.BigEndian, &bits); err != nil {
+		return nil, err
+	}
+	imaSignature, err := ioutil.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(imaSignature) != (int(bits)+7)/8 {
+		return nil, fmt.Errorf(
+			"ima: expected signature length of %d, got %d",
+			(int(bits)+7)/8,
+			len(imaSignature),
+		)
+	}
+
+	return &SignatureV1{
+		Header:    line,
+		Signature: imaSignature,
+	}, nil
+}
+
+// Version 1 Key IDs are the last 8 bytes of a SHA1 hash over the evmctl
+// encoding of the RSA Public Key, which is a small header followed by the
+// modulus and exponent as MPIs. Only RSA keys have a version 1 Key ID.
+func PublicKeyIdV1(pubKey crypto.PublicKey) ([8]byte, error) {
+	var rsaPublicKey *rsa.PublicKey
+	switch pubKey.(type) {
+	case rsa.PublicKey:
+		pubKey := pubKey.(rsa.PublicKey)
+		rsaPublicKey = &pubKey
+	case *rsa.PublicKey:
+		rsaPublicKey = pubKey.(*rsa.PublicKey)
+	default:
+		return [8]byte{}, fmt.Errorf("ima: public key format not supported")
+	}
+
+	hash := sha1.New()
+	// version, timestamp, algorithm, and MPI count
+	hash.Write([]byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02})
+	hash.Write(mpi(rsaPublicKey.N))
+	hash.Write(mpi(big.NewInt(int64(rsaPublicKey.E))))
+	hashSum := hash.Sum(nil)
+
+	id := [8]byte{}
+	copy(id[:], hashSum[12:])
+	return id, nil
+}
+
+// Encode a big.Int as an MPI, which is the number of bits as a big endian
+// uint16, followed by the big endian bytes of the number.
+func mpi(n *big.Int) []byte {
+	bits := n.BitLen()
+	return append([]byte{byte(bits >> 8), byte(bits)}, n.Bytes()...)
+}
+
+// Get the data the Signature is actually made over, given the digest of the
+// file. For version 1 signatures, this is the hash of the digest followed by
+// the header (without the leading Magic byte).
+func (s SignatureV1) SignedDigest(digest []byte) ([]byte, error) {
+	hashFunc, err := s.Header.Hash()
+	if err != nil {
+		return nil, err
+	}
+	header := bytes.Buffer{}
+	if err := binary.Write(&header, binary.BigEndian, s.Header); err != nil {
+		return nil, err
+	}
+
+	hash := hashFunc.New()
+	hash.Write(digest)
+	hash.Write(header.Bytes()[1:])
+	return hash.Sum(nil), nil
+}
+
+// Verify the Signature with the provided VerifyOptions. This behaves the same
+// as Signature.Verify, except that keys are matched using the version 1
+// Key ID, so the VerifyOptions Keys must also be a KeyResolverV1, such as a
+// KeyPool.
+//
+// The VerifyOptions Hash is not used, since the signed data is always hashed
+// with the algorithm in the header.
+func (s SignatureV1) Verify(opts VerifyOptions) (crypto.PublicKey, error) {
+	candidate, err := s.Match(opts)
+	if err != nil {
+		return nil, err
+	}
+	return candidate.Key, nil
+}
+
+// Verify the Signature the same way as Verify, but return the Candidate that
+// made it, which includes the key's Certificate, if it has one.
+func (s SignatureV1) Match(opts VerifyOptions) (*Candidate, error) {
+	if opts.Blocklist != nil && opts.Blocklist.BlocksDigest(opts.Digest) {
+		return nil, RevokedDigest
+	}
+	resolver, ok := opts.Keys.(KeyResolverV1)
+	if !ok {
+		return nil, UnknownSigner
+	}
+	candidates, err := resolver.ResolveV1(s.Header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, UnknownSigner
+	}
+	// A candidate that made the signature but was turned away for some
+	// other reason is a far more useful error than a later candidate's
+	// BadSignature.
+	var verifyErr, checkErr error
+	for _, el := range candidates {
+		if err := s.VerifyKey(el.Key, opts.Digest); err != nil {
+			verifyErr = err
+			continue
+		}
+		if err := opts.checkCandidate(el); err != nil {
+			checkErr = err
+			continue
+		}
+		return &el, nil
+	}
+	if checkErr != nil {
+		return nil, checkErr
+	}
+	return nil, verifyErr
+}
+
+// Verify the Signature over the digest for a specific RSA key.
+//
+// Version 1 signatures are raw PKCS#1 v1.5 signatures, without the ASN.1
+// DigestInfo prefix that's used in later versions. Errors are the same as
+// for Signature.VerifyKey.
+func (s SignatureV1) VerifyKey(pub crypto.PublicKey, digest []byte) error {
+	var rsaPublicKey *rsa.PublicKey
+	switch pub.(type) {
+	case rsa.PublicKey:
+		pub := pub.(rsa.PublicKey)
+		rsaPublicKey = &pub
+	case *rsa.PublicKey:
+		rsaPublicKey = pub.(*rsa.PublicKey)
+	default:
+		return fmt.Errorf("%w: PublicKey format not understood", UnsupportedAlgorithm)
+	}
+
+	signedDigest, err := s.SignedDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	// The MPI encoding drops leading zeros, which rsa expects to be there.
+	signature := s.Signature
+	if size := rsaPublicKey.Size(); len(signature) < size {
+		signature = append(make([]byte, size-len(signature)), signature...)
+	}
+	return verifyRSA(rsaPublicKey, crypto.Hash(0), signedDigest, signature)
+}
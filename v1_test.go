@@ -0,0 +1,159 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ima_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"encoding/binary"
+
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+
+	"pault.ag/go/ima"
+)
+
+// Create a version 1 signature the same way early versions of evmctl did.
+func signV1(t *testing.T, key *rsa.PrivateKey, digest []byte) []byte {
+	keyId, err := ima.PublicKeyIdV1(key.Public())
+	isok(t, err)
+
+	header := ima.SignatureV1Header{
+		Magic:         ima.XattrDigSig,
+		Version:       ima.SignatureVersion1,
+		Timestamp:     1500000000,
+		HashAlgorithm: 0x01,
+		KeyID:         keyId,
+		MPICount:      1,
+	}
+	out := bytes.Buffer{}
+	isok(t, binary.Write(&out, binary.BigEndian, header))
+
+	hash := sha256.New()
+	hash.Write(digest)
+	hash.Write(out.Bytes()[1:])
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.Hash(0), hash.Sum(nil))
+	isok(t, err)
+	for signature[0] == 0x00 {
+		signature = signature[1:]
+	}
+
+	bits := (len(signature)-1)*8 + bitLen(signature[0])
+	isok(t, binary.Write(&out, binary.BigEndian, uint16(bits)))
+	out.Write(signature)
+	return out.Bytes()
+}
+
+func bitLen(b byte) int {
+	n := 0
+	for ; b != 0; b >>= 1 {
+		n++
+	}
+	return n
+}
+
+func TestParseV1(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	digest := sha256.Sum256([]byte("Totally real ELF no tricks"))
+
+	sigBytes := signV1(t, key, digest[:])
+
+	_, err = ima.Parse(sigBytes)
+	notok(t, err)
+
+	sig, err := ima.ParseV1(sigBytes)
+	isok(t, err)
+	assert(t, sig.Header.Version == ima.SignatureVersion1)
+	assert(t, sig.Header.Timestamp == 1500000000)
+
+	hash, err := sig.Header.Hash()
+	isok(t, err)
+	assert(t, *hash == crypto.SHA256)
+
+	value, err := ima.ParseXattr(sigBytes)
+	isok(t, err)
+	_, ok := value.(*ima.SignatureV1)
+	assert(t, ok)
+}
+
+func TestVerifyV1(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	digest := sha256.Sum256([]byte("Totally real ELF no tricks"))
+
+	sig, err := ima.ParseV1(signV1(t, key, digest[:]))
+	isok(t, err)
+
+	isok(t, sig.VerifyKey(key.PublicKey, digest[:]))
+	notok(t, sig.VerifyKey(key.PublicKey, digest[1:]))
+	assert(t, errors.Is(sig.VerifyKey(key.PublicKey, digest[1:]), ima.DigestMismatch))
+
+	pool := ima.NewKeyPool()
+	_, err = sig.Verify(ima.VerifyOptions{Keys: pool, Digest: digest[:]})
+	assert(t, err == ima.UnknownSigner)
+
+	isok(t, pool.AddKey(key.Public()))
+	usedKey, err := sig.Verify(ima.VerifyOptions{Keys: pool, Digest: digest[:]})
+	isok(t, err)
+	assert(t, usedKey == key.Public())
+}
+
+func TestPublicKeyIdV1(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+
+	blob := []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02}
+	blob = append(blob, byte(key.N.BitLen()>>8), byte(key.N.BitLen()))
+	blob = append(blob, key.N.Bytes()...)
+	blob = append(blob, 0x00, 0x11, 0x01, 0x00, 0x01)
+	hash := sha1.Sum(blob)
+
+	id, err := ima.PublicKeyIdV1(key.Public())
+	isok(t, err)
+	assert(t, bytes.Compare(id[:], hash[12:]) == 0)
+}
+
+func TestMatchV1RevokedCandidate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	digest := sha256.Sum256([]byte("Totally real ELF no tricks"))
+
+	sig, err := ima.ParseV1(signV1(t, key, digest[:]))
+	isok(t, err)
+
+	keyId, err := ima.PublicKeyId(key.Public())
+	isok(t, err)
+	blocklist := ima.NewBlocklist()
+	blocklist.AddKeyId(keyId)
+
+	_, err = sig.Match(ima.VerifyOptions{
+		Keys: candidateList{
+			{Key: key.Public()},
+			{Key: other.Public()},
+		},
+		Digest:    digest[:],
+		Blocklist: blocklist,
+	})
+	assert(t, err == ima.RevokedKey)
+}
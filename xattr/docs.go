@@ -1,3 +1,8 @@
 // Bindings to read, write and validate IMA signatures stored as an xattr
 // on a file.
+//
+// Functions that take an *os.File use the open file descriptor for the xattr
+// as well as to measure the file, so a file renamed or replaced after it was
+// opened can't be mixed up with another. Use the Path and Link variants,
+// such as ReadPath and ReadLink, to work on a path instead.
 package xattr
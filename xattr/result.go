@@ -0,0 +1,226 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xattr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"crypto"
+	"crypto/x509"
+
+	"pault.ag/go/ima"
+)
+
+// Outcome of verifying the signature of a file.
+type Status int
+
+const (
+	// The signature is valid.
+	StatusValid Status = iota
+
+	// The file has no signature. The error wraps Unsigned.
+	StatusUnsigned
+
+	// None of the keys have the signature's Key ID. The error wraps
+	// ima.UnknownSigner.
+	StatusUnknownKey
+
+	// The signature was made by a known key, but over a different digest,
+	// so the file has changed since it was signed. The error wraps
+	// ima.DigestMismatch.
+	StatusDigestMismatch
+
+	// The signature doesn't check out against the keys with its Key ID, or
+	// can't be parsed. For keys other than RSA, this is also what a changed
+	// file looks like. The error wraps ima.BadSignature.
+	StatusBadSignature
+
+	// The hash algorithm, signature version, or key type isn't supported.
+	// The error wraps ima.UnsupportedAlgorithm, ima.UnknownHash or
+	// ima.HashUnavailable.
+	StatusUnsupportedAlgorithm
+
+	// The file digest, or the key that signed it, is in the Blocklist. The
+	// error wraps ima.RevokedDigest or ima.RevokedKey.
+	StatusRevoked
+
+	// The signature is valid, but the Certificate of the key failed the
+	// CertificatePolicy. The error is an ima.CertificateError.
+	StatusBadCertificate
+
+	// Something else went wrong, such as an I/O error reading the file, or
+	// looking up keys.
+	StatusError
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusValid:
+		return "valid"
+	case StatusUnsigned:
+		return "unsigned"
+	case StatusUnknownKey:
+		return "unknown key"
+	case StatusDigestMismatch:
+		return "digest mismatch"
+	case StatusBadSignature:
+		return "bad signature"
+	case StatusUnsupportedAlgorithm:
+		return "unsupported algorithm"
+	case StatusRevoked:
+		return "revoked"
+	case StatusBadCertificate:
+		return "bad certificate"
+	case StatusError:
+		return "error"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+var (
+	// This is returned when a file has no signature, either because the
+	// Store has nothing for it, or because it only holds a digest.
+	Unsigned error = fmt.Errorf("xattr: file is not signed")
+)
+
+// Everything learned while verifying the signature of a file.
+type VerificationResult struct {
+	// Outcome of the verification.
+	Status Status
+
+	// Error explaining the Status, or nil if the Status is StatusValid.
+	Err error
+
+	// Key ID from the signature; 4 bytes, or 8 for a version 1 signature.
+	// This is nil if the file has no signature.
+	KeyId []byte
+
+	// Hash algorithm the file was measured with, which is the one named by
+	// the signature.
+	Hash *ima.Hash
+
+	// Digest of the file, if it could be measured.
+	Digest []byte
+
+	// Key that made the signature, if it's valid.
+	Key crypto.PublicKey
+
+	// Certificate of the Key, if it's valid and was added with one.
+	Certificate *x509.Certificate
+}
+
+// Verify the file the same way as VerifyWithOptions, and return everything
+// learned along the way, rather than just an error.
+func VerifyResult(fd *os.File, opts ima.VerifyOptions) *VerificationResult {
+	return VerifyStoreResult(defaultStore(), fd, opts)
+}
+
+// Verify the file the same way as VerifyStore, and return everything learned
+// along the way, rather than just an error.
+func VerifyStoreResult(store Store, fd *os.File, opts ima.VerifyOptions) *VerificationResult {
+	ret := &VerificationResult{}
+	ret.Err = ret.verify(store, fd, opts)
+	ret.Status = statusOf(ret.Err)
+	return ret
+}
+
+// Load the signature from the Store, measure the file, and check the
+// signature against it, filling in the VerificationResult as it goes.
+func (r *VerificationResult) verify(store Store, fd *os.File, opts ima.VerifyOptions) error {
+	name := fmt.Sprintf("%s of %s", store, fd.Name())
+	data, err := store.Get(fd)
+	if IsNotExist(err) {
+		return fmt.Errorf("%w: %w", Unsigned, err)
+	} else if err != nil {
+		return err
+	}
+	value, err := ima.ParseXattr(data)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ima.BadSignature, err)
+	}
+
+	switch sig := value.(type) {
+	case *ima.Signature:
+		r.KeyId = append([]byte{}, sig.Header.KeyID[:]...)
+		r.Hash, err = sig.Header.Algorithm()
+	case *ima.SignatureV1:
+		r.KeyId = append([]byte{}, sig.Header.KeyID[:]...)
+		var hashFunc *crypto.Hash
+		if hashFunc, err = sig.Header.Hash(); err == nil {
+			r.Hash, err = ima.HashFunctions.ToHash(*hashFunc)
+		}
+	default:
+		return fmt.Errorf("%w: %s does not contain a signature", Unsigned, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	hash, err := r.Hash.New()
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(hash, fd); err != nil {
+		return err
+	}
+	r.Digest = hash.Sum(nil)
+	opts.Digest = r.Digest
+	opts.Hash = r.Hash.Hash
+
+	var candidate *ima.Candidate
+	switch sig := value.(type) {
+	case *ima.SignatureV1:
+		candidate, err = sig.Match(opts)
+	case *ima.Signature:
+		candidate, err = sig.Match(opts)
+	}
+	if err != nil {
+		return err
+	}
+	r.Key = candidate.Key
+	r.Certificate = candidate.Certificate
+	return nil
+}
+
+// Work out the Status from the error returned while verifying.
+func statusOf(err error) Status {
+	certErr := ima.CertificateError{}
+	switch {
+	case err == nil:
+		return StatusValid
+	case errors.Is(err, Unsigned):
+		return StatusUnsigned
+	case errors.Is(err, ima.UnknownSigner):
+		return StatusUnknownKey
+	case errors.Is(err, ima.DigestMismatch):
+		return StatusDigestMismatch
+	case errors.Is(err, ima.BadSignature):
+		return StatusBadSignature
+	case errors.Is(err, ima.UnsupportedAlgorithm),
+		errors.Is(err, ima.UnknownHash),
+		errors.Is(err, ima.HashUnavailable):
+		return StatusUnsupportedAlgorithm
+	case errors.Is(err, ima.RevokedDigest), errors.Is(err, ima.RevokedKey):
+		return StatusRevoked
+	case errors.As(err, &certErr):
+		return StatusBadCertificate
+	default:
+		return StatusError
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xattr_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+
+	"pault.ag/go/ima"
+	"pault.ag/go/ima/xattr"
+)
+
+// Sign a file with the signer into a new MemoryStore, and return the file
+// seek'd back to the start.
+func signedFile(t *testing.T, signer crypto.Signer, opts crypto.SignerOpts) (*os.File, *xattr.MemoryStore) {
+	tmpfile, err := ioutil.TempFile("", "ima-result")
+	isok(t, err)
+	_, err = tmpfile.Write([]byte("totally legit elf af"))
+	isok(t, err)
+	tmpfile.Seek(0, 0)
+	store := xattr.NewMemoryStore()
+	isok(t, xattr.SignStore(store, signer, rand.Reader, opts, tmpfile))
+	tmpfile.Seek(0, 0)
+	return tmpfile, store
+}
+
+func closeFile(fd *os.File) {
+	fd.Close()
+	os.Remove(fd.Name())
+}
+
+func TestResultValid(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	keys := ima.NewKeyPool()
+	isok(t, keys.AddKey(key.Public()))
+
+	fd, store := signedFile(t, key, crypto.SHA256)
+	defer closeFile(fd)
+
+	result := xattr.VerifyStoreResult(store, fd, ima.VerifyOptions{Keys: keys})
+	isok(t, result.Err)
+	assert(t, result.Status == xattr.StatusValid)
+	assert(t, result.Status.String() == "valid")
+	assert(t, result.Key == key.Public())
+	assert(t, result.Certificate == nil)
+	assert(t, result.Hash.Id == ima.SHA256.Id)
+	digest := sha256.Sum256([]byte("totally legit elf af"))
+	assert(t, bytes.Equal(result.Digest, digest[:]))
+	id, err := ima.PublicKeyId(key.Public())
+	isok(t, err)
+	assert(t, bytes.Equal(result.KeyId, id[:]))
+}
+
+func TestResultUnsigned(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "ima-result")
+	isok(t, err)
+	defer closeFile(tmpfile)
+
+	store := xattr.NewMemoryStore()
+	result := xattr.VerifyStoreResult(store, tmpfile, ima.VerifyOptions{})
+	assert(t, result.Status == xattr.StatusUnsigned)
+	assert(t, errors.Is(result.Err, xattr.Unsigned))
+	assert(t, xattr.IsNotExist(result.Err))
+	assert(t, result.KeyId == nil)
+
+	// A bare digest isn't a signature.
+	digest := make([]byte, 34)
+	digest[0] = ima.XattrDigestNG
+	digest[1] = ima.SHA256.Id
+	isok(t, store.Set(tmpfile, digest))
+	result = xattr.VerifyStoreResult(store, tmpfile, ima.VerifyOptions{})
+	assert(t, result.Status == xattr.StatusUnsigned)
+	assert(t, errors.Is(xattr.VerifyStore(store, tmpfile, ima.VerifyOptions{}), xattr.Unsigned))
+}
+
+func TestResultFailures(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	keys := ima.NewKeyPool()
+	isok(t, keys.AddKey(key.Public()))
+	opts := ima.VerifyOptions{Keys: keys}
+
+	fd, store := signedFile(t, key, crypto.SHA256)
+	defer closeFile(fd)
+	value, err := store.Get(fd)
+	isok(t, err)
+
+	result := xattr.VerifyStoreResult(store, fd, ima.VerifyOptions{Keys: ima.NewKeyPool()})
+	assert(t, result.Status == xattr.StatusUnknownKey)
+	assert(t, errors.Is(result.Err, ima.UnknownSigner))
+	assert(t, len(result.Digest) == 32)
+
+	// A corrupt signature.
+	corrupt := append([]byte{}, value...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	isok(t, store.Set(fd, corrupt))
+	fd.Seek(0, 0)
+	result = xattr.VerifyStoreResult(store, fd, opts)
+	assert(t, result.Status == xattr.StatusBadSignature)
+	assert(t, errors.Is(result.Err, ima.BadSignature))
+
+	// An unknown hash algorithm.
+	corrupt = append([]byte{}, value...)
+	corrupt[2] = 0xee
+	isok(t, store.Set(fd, corrupt))
+	fd.Seek(0, 0)
+	result = xattr.VerifyStoreResult(store, fd, opts)
+	assert(t, result.Status == xattr.StatusUnsupportedAlgorithm)
+	assert(t, result.KeyId != nil)
+
+	// A revoked digest.
+	isok(t, store.Set(fd, value))
+	digest := sha256.Sum256([]byte("totally legit elf af"))
+	blocklist := ima.NewBlocklist()
+	blocklist.AddDigest(digest[:])
+	fd.Seek(0, 0)
+	result = xattr.VerifyStoreResult(store, fd, ima.VerifyOptions{Keys: keys, Blocklist: blocklist})
+	assert(t, result.Status == xattr.StatusRevoked)
+	assert(t, errors.Is(result.Err, ima.RevokedDigest))
+
+	// And the file changing after it was signed.
+	_, err = fd.Write([]byte("evil"))
+	isok(t, err)
+	fd.Seek(0, 0)
+	result = xattr.VerifyStoreResult(store, fd, opts)
+	assert(t, result.Status == xattr.StatusDigestMismatch)
+	assert(t, errors.Is(result.Err, ima.DigestMismatch))
+	assert(t, result.Key == nil)
+}
+
+func TestResultECDSA(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	isok(t, err)
+	keys := ima.NewKeyPool()
+	isok(t, keys.AddKey(key.Public()))
+
+	fd, store := signedFile(t, key, crypto.SHA256)
+	defer closeFile(fd)
+
+	// ECDSA signatures don't give away what they were made over.
+	_, err = fd.Write([]byte("evil"))
+	isok(t, err)
+	fd.Seek(0, 0)
+	result := xattr.VerifyStoreResult(store, fd, ima.VerifyOptions{Keys: keys})
+	assert(t, result.Status == xattr.StatusBadSignature)
+}
+
+func TestResultCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	isok(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     time.Now().Add(-time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	isok(t, err)
+	cert, err := x509.ParseCertificate(der)
+	isok(t, err)
+	keys := ima.NewKeyPool()
+	isok(t, keys.AddCertificate(cert))
+
+	fd, store := signedFile(t, key, ima.SignatureOptions{Hash: ima.SHA256, Certificate: cert})
+	defer closeFile(fd)
+
+	result := xattr.VerifyStoreResult(store, fd, ima.VerifyOptions{Keys: keys})
+	assert(t, result.Status == xattr.StatusValid)
+	assert(t, result.Certificate == cert)
+
+	fd.Seek(0, 0)
+	result = xattr.VerifyStoreResult(store, fd, ima.VerifyOptions{
+		Keys:              keys,
+		CertificatePolicy: ima.CertificatesEnforce,
+	})
+	assert(t, result.Status == xattr.StatusBadCertificate)
+	assert(t, errors.Is(result.Err, ima.CertificateExpired))
+}
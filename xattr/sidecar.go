@@ -0,0 +1,138 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xattr
+
+import (
+	"crypto"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"pault.ag/go/ima"
+)
+
+// Extension added to a file's path to get the path of its detached
+// signature file, the same as evmctl --sigfile.
+const SidecarExtension = ".sig"
+
+// Get the path of the detached signature file for the file at path.
+func SidecarPath(path string) string {
+	return path + SidecarExtension
+}
+
+// Read the raw bytes of the detached signature file, which holds exactly
+// what would otherwise be stored in the ima xattr.
+//
+// Unlike the xattr, the signature file can only be found by the path the
+// file was opened with, so fd.Name() must still refer to the same file.
+//
+// If the signature file doesn't exist, an error satisfying os.IsNotExist
+// will be returned.
+func ReadSidecar(fd *os.File) ([]byte, error) {
+	return Sidecar.Get(fd)
+}
+
+// Write a serialized ima xattr value, such as the output of ima.Sign, to the
+// file's detached signature file. The value must parse with ima.ParseXattr.
+func WriteSidecar(fd *os.File, value []byte) error {
+	if _, err := ima.ParseXattr(value); err != nil {
+		return err
+	}
+	return Sidecar.Set(fd, value)
+}
+
+// SidecarStore keeps signatures in detached signature files next to the
+// signed file, named by adding the Extension to its path, the same as
+// evmctl --sigfile.
+//
+// Unlike an xattr, the signature file can only be found by the path the file
+// was opened with, so fd.Name() must still refer to the same file.
+type SidecarStore struct {
+	// Extension added to the file's path, such as .sig.
+	Extension string
+}
+
+func (s SidecarStore) path(fd *os.File) string {
+	return fd.Name() + s.Extension
+}
+
+// Read the detached signature file.
+func (s SidecarStore) Get(fd *os.File) ([]byte, error) {
+	return ioutil.ReadFile(s.path(fd))
+}
+
+// Write the detached signature file.
+func (s SidecarStore) Set(fd *os.File, value []byte) error {
+	return ioutil.WriteFile(s.path(fd), value, 0644)
+}
+
+// Remove the detached signature file.
+func (s SidecarStore) Remove(fd *os.File) error {
+	return os.Remove(s.path(fd))
+}
+
+// List the files in the directory that have a detached signature file.
+func (s SidecarStore) List(dir string) ([]string, error) {
+	return listDir(dir, func(path string, info os.FileInfo) bool {
+		if info.IsDir() || strings.HasSuffix(path, s.Extension) {
+			return false
+		}
+		_, err := os.Lstat(path + s.Extension)
+		return err == nil
+	})
+}
+
+func (s SidecarStore) String() string {
+	return s.Extension + " file"
+}
+
+// Load the ima signature from the file's detached signature file, rather
+// than the xattr, and parse it into an ima.Signature block.
+//
+// If the signature file doesn't exist, an error satisfying os.IsNotExist
+// will be returned.
+func ParseSidecar(fd *os.File) (*ima.Signature, error) {
+	data, err := ReadSidecar(fd)
+	if err != nil {
+		return nil, err
+	}
+	return ima.Parse(data)
+}
+
+// Load the detached signature file, and parse it into whatever type of
+// ima.XattrValue is stored there, like ParseValue.
+func ParseSidecarValue(fd *os.File) (ima.XattrValue, error) {
+	return ParseStore(Sidecar, fd)
+}
+
+// Verify the file against its detached signature file, rather than the
+// xattr. Otherwise, this is the same as Verify.
+func VerifySidecar(fd *os.File, pool ima.KeyPool) error {
+	return VerifySidecarWithOptions(fd, ima.VerifyOptions{Keys: pool})
+}
+
+// Verify the file against its detached signature file, rather than the
+// xattr. Otherwise, this is the same as VerifyWithOptions.
+func VerifySidecarWithOptions(fd *os.File, opts ima.VerifyOptions) error {
+	return VerifyStore(Sidecar, fd, opts)
+}
+
+// Measure the file, sign the digest, and write the signature to the file's
+// detached signature file, rather than the xattr. Otherwise, this is the
+// same as Sign.
+func SignSidecar(signer crypto.Signer, rand io.Reader, opts crypto.SignerOpts, fd *os.File) error {
+	return SignStore(Sidecar, signer, rand, opts, fd)
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xattr_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+
+	"pault.ag/go/ima"
+	"pault.ag/go/ima/xattr"
+)
+
+func TestSidecar(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+
+	tmpfile, err := ioutil.TempFile("", "ima-sidecar")
+	isok(t, err)
+	defer os.Remove(tmpfile.Name())
+	defer os.Remove(xattr.SidecarPath(tmpfile.Name()))
+	defer tmpfile.Close()
+	_, err = tmpfile.Write([]byte("totally legit elf af"))
+	isok(t, err)
+
+	keys := ima.NewKeyPool()
+	isok(t, keys.AddKey(key.Public()))
+
+	tmpfile.Seek(0, 0)
+	_, err = xattr.ParseSidecar(tmpfile)
+	assert(t, os.IsNotExist(err))
+	notok(t, xattr.VerifySidecar(tmpfile, keys))
+
+	tmpfile.Seek(0, 0)
+	isok(t, xattr.SignSidecar(key, rand.Reader, crypto.SHA256, tmpfile))
+	assert(t, xattr.SidecarPath(tmpfile.Name()) == tmpfile.Name()+".sig")
+
+	sig, err := xattr.ParseSidecar(tmpfile)
+	isok(t, err)
+	id, err := ima.PublicKeyId(key.Public())
+	isok(t, err)
+	assert(t, sig.Header.KeyID == id)
+
+	tmpfile.Seek(0, 0)
+	isok(t, xattr.VerifySidecar(tmpfile, keys))
+
+	_, err = tmpfile.Write([]byte("but with a backdoor"))
+	isok(t, err)
+	tmpfile.Seek(0, 0)
+	notok(t, xattr.VerifySidecar(tmpfile, keys))
+}
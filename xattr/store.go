@@ -0,0 +1,314 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xattr
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/sys/unix"
+
+	"pault.ag/go/ima"
+)
+
+// Store is somewhere that the serialized IMA signature of a file is kept,
+// such as the security.ima xattr, or a detached signature file.
+//
+// If a file doesn't have a signature, Get and Remove return an error which
+// satisfies IsNotExist.
+type Store interface {
+	// Get the serialized signature of the file.
+	Get(fd *os.File) ([]byte, error)
+
+	// Set the serialized signature of the file, replacing any that was
+	// there before.
+	Set(fd *os.File, value []byte) error
+
+	// Remove the signature of the file.
+	Remove(fd *os.File) error
+
+	// List the names of the files in the directory that have a signature.
+	List(dir string) ([]string, error)
+}
+
+var (
+	// The security.ima xattr, which is what the kernel appraises files
+	// against. Setting it needs CAP_SYS_ADMIN.
+	IMA = XattrStore{Name: "security.ima"}
+
+	// The user.ima xattr, which any user that can write to a file can set.
+	// The kernel ignores it, but it's handy to stage signatures, or for
+	// tests.
+	UserIMA = XattrStore{Name: "user.ima"}
+
+	// Detached signature files next to the signed file, with the
+	// SidecarExtension.
+	Sidecar = SidecarStore{Extension: SidecarExtension}
+)
+
+// Check if the error is from a Store that doesn't have a signature for a
+// file.
+func IsNotExist(err error) bool {
+	return errors.Is(err, unix.ENODATA) || errors.Is(err, os.ErrNotExist)
+}
+
+// XattrStore keeps signatures in an xattr of the file. The xattr is read and
+// written through the open file descriptor, rather than by looking up
+// fd.Name() again, which also works for descriptors opened with O_PATH.
+type XattrStore struct {
+	// Name of the xattr, such as security.ima.
+	Name string
+}
+
+// Get the serialized signature from the xattr. If the xattr doesn't exist,
+// golang/x/sys/unix.ENODATA will be returned.
+func (x XattrStore) Get(fd *os.File) ([]byte, error) {
+	return getxattr(func(dest []byte) (int, error) {
+		size, err := unix.Fgetxattr(int(fd.Fd()), x.Name, dest)
+		if err == unix.EBADF {
+			// O_PATH descriptors can't be used with fgetxattr, but the
+			// /proc/self/fd link to them can be, without resolving the
+			// file's path again.
+			return unix.Getxattr(procPath(fd), x.Name, dest)
+		}
+		return size, err
+	})
+}
+
+// Get the serialized signature from the xattr of the file at path, following
+// it if it's a symlink.
+func (x XattrStore) GetPath(path string) ([]byte, error) {
+	return getxattr(func(dest []byte) (int, error) {
+		return unix.Getxattr(path, x.Name, dest)
+	})
+}
+
+// Get the serialized signature from the xattr of the file at path, without
+// following it if it's a symlink, the same as lgetxattr(2).
+func (x XattrStore) GetLink(path string) ([]byte, error) {
+	return getxattr(func(dest []byte) (int, error) {
+		return unix.Lgetxattr(path, x.Name, dest)
+	})
+}
+
+// Set the xattr to the serialized signature.
+func (x XattrStore) Set(fd *os.File, value []byte) error {
+	err := unix.Fsetxattr(int(fd.Fd()), x.Name, value, 0x00)
+	if err == unix.EBADF {
+		return unix.Setxattr(procPath(fd), x.Name, value, 0x00)
+	}
+	return err
+}
+
+// Set the xattr of the file at path, following it if it's a symlink.
+func (x XattrStore) SetPath(path string, value []byte) error {
+	return unix.Setxattr(path, x.Name, value, 0x00)
+}
+
+// Set the xattr of the file at path, without following it if it's a
+// symlink, the same as lsetxattr(2).
+func (x XattrStore) SetLink(path string, value []byte) error {
+	return unix.Lsetxattr(path, x.Name, value, 0x00)
+}
+
+// Remove the xattr.
+func (x XattrStore) Remove(fd *os.File) error {
+	err := unix.Fremovexattr(int(fd.Fd()), x.Name)
+	if err == unix.EBADF {
+		return unix.Removexattr(procPath(fd), x.Name)
+	}
+	return err
+}
+
+// List the files in the directory with the xattr set. Symlinks aren't
+// followed.
+func (x XattrStore) List(dir string) ([]string, error) {
+	return listDir(dir, func(path string, info os.FileInfo) bool {
+		_, err := unix.Lgetxattr(path, x.Name, nil)
+		return err == nil
+	})
+}
+
+func (x XattrStore) String() string {
+	return x.Name
+}
+
+// Call get to find the size of the xattr, and then again to read it.
+func getxattr(get func(dest []byte) (int, error)) ([]byte, error) {
+	size, err := get(nil)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	size, err = get(data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:size], nil
+}
+
+// Path to the /proc/self/fd link for fd.
+func procPath(fd *os.File) string {
+	return fmt.Sprintf("/proc/self/fd/%d", fd.Fd())
+}
+
+// List the names of the entries in dir that match.
+func listDir(dir string, match func(path string, info os.FileInfo) bool) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, entry := range entries {
+		if match(filepath.Join(dir, entry.Name()), entry) {
+			ret = append(ret, entry.Name())
+		}
+	}
+	return ret, nil
+}
+
+// MemoryStore keeps signatures in memory, which is handy for tests, or to
+// check signatures before they're written out. Like an xattr, signatures
+// belong to the file rather than its path, so they follow a file that's
+// renamed, and ENODATA is returned for a file without one.
+//
+// A MemoryStore is safe for concurrent use.
+type MemoryStore struct {
+	lock   sync.Mutex
+	values map[fileId][]byte
+}
+
+// Device and inode of a file.
+type fileId struct {
+	dev uint64
+	ino uint64
+}
+
+// Create an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{values: map[fileId][]byte{}}
+}
+
+func fdId(fd *os.File) (fileId, error) {
+	stat := unix.Stat_t{}
+	if err := unix.Fstat(int(fd.Fd()), &stat); err != nil {
+		return fileId{}, err
+	}
+	return fileId{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, nil
+}
+
+// Get the signature of the file.
+func (m *MemoryStore) Get(fd *os.File) ([]byte, error) {
+	id, err := fdId(fd)
+	if err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	value, ok := m.values[id]
+	if !ok {
+		return nil, unix.ENODATA
+	}
+	return append([]byte{}, value...), nil
+}
+
+// Set the signature of the file.
+func (m *MemoryStore) Set(fd *os.File, value []byte) error {
+	id, err := fdId(fd)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.values[id] = append([]byte{}, value...)
+	return nil
+}
+
+// Remove the signature of the file.
+func (m *MemoryStore) Remove(fd *os.File) error {
+	id, err := fdId(fd)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.values[id]; !ok {
+		return unix.ENODATA
+	}
+	delete(m.values, id)
+	return nil
+}
+
+// List the files in the directory with a signature. Symlinks aren't
+// followed.
+func (m *MemoryStore) List(dir string) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return listDir(dir, func(path string, info os.FileInfo) bool {
+		stat := unix.Stat_t{}
+		if err := unix.Lstat(path, &stat); err != nil {
+			return false
+		}
+		_, ok := m.values[fileId{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}]
+		return ok
+	})
+}
+
+func (m *MemoryStore) String() string {
+	return "memory store"
+}
+
+// Load the signature from the Store, and parse it into whatever type of
+// ima.XattrValue is stored there.
+func ParseStore(store Store, fd *os.File) (ima.XattrValue, error) {
+	data, err := store.Get(fd)
+	if err != nil {
+		return nil, err
+	}
+	return ima.ParseXattr(data)
+}
+
+// Load the signature from the Store, measure the file, and check the
+// signature against it with the ima.VerifyOptions. The Digest and Hash are
+// always replaced with the measurement of the file.
+//
+// This code expects the file is seek'd to the origin of the file, and will
+// return the file at its EOF.
+//
+// The error can be checked with errors.Is against the sentinel errors listed
+// for each Status; use VerifyStoreResult to get the Status itself.
+func VerifyStore(store Store, fd *os.File, opts ima.VerifyOptions) error {
+	return VerifyStoreResult(store, fd, opts).Err
+}
+
+// Measure the file, sign the digest with the provided signer, and put the
+// signature in the Store. The entropy source and signer options will be
+// passed directly back into the underlying Signature call.
+//
+// This code expects the file is seek'd to the origin of the file, and will
+// return the file at its EOF.
+func SignStore(store Store, signer crypto.Signer, rand io.Reader, opts crypto.SignerOpts, fd *os.File) error {
+	sig, err := measureAndSign(signer, rand, opts, fd)
+	if err != nil {
+		return err
+	}
+	return store.Set(fd, sig)
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xattr_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+
+	"pault.ag/go/ima"
+	"pault.ag/go/ima/xattr"
+)
+
+func TestStores(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	digest := make([]byte, 32)
+	value, err := ima.Sign(key, rand.Reader, digest, crypto.SHA256)
+	isok(t, err)
+
+	for _, store := range []xattr.Store{
+		xattr.UserIMA,
+		xattr.Sidecar,
+		xattr.NewMemoryStore(),
+	} {
+		dir, err := ioutil.TempDir("", "ima-store")
+		isok(t, err)
+		defer os.RemoveAll(dir)
+		isok(t, ioutil.WriteFile(filepath.Join(dir, "elf"), []byte("totally legit elf af"), 0644))
+		isok(t, ioutil.WriteFile(filepath.Join(dir, "other"), []byte("unsigned"), 0644))
+
+		fd, err := os.Open(filepath.Join(dir, "elf"))
+		isok(t, err)
+		defer fd.Close()
+
+		_, err = store.Get(fd)
+		assert(t, xattr.IsNotExist(err))
+		names, err := store.List(dir)
+		isok(t, err)
+		assert(t, len(names) == 0)
+
+		isok(t, store.Set(fd, value))
+		read, err := store.Get(fd)
+		isok(t, err)
+		assert(t, bytes.Equal(read, value))
+
+		names, err = store.List(dir)
+		isok(t, err)
+		assert(t, len(names) == 1)
+		assert(t, names[0] == "elf")
+
+		isok(t, store.Remove(fd))
+		_, err = store.Get(fd)
+		assert(t, xattr.IsNotExist(err))
+		assert(t, xattr.IsNotExist(store.Remove(fd)))
+	}
+}
+
+func TestSignVerifyStore(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	keys := ima.NewKeyPool()
+	isok(t, keys.AddKey(key.Public()))
+	opts := ima.VerifyOptions{Keys: keys}
+
+	tmpfile, err := ioutil.TempFile("", "ima-store")
+	isok(t, err)
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+	_, err = tmpfile.Write([]byte("totally legit elf af"))
+	isok(t, err)
+
+	memory := xattr.NewMemoryStore()
+	tmpfile.Seek(0, 0)
+	isok(t, xattr.SignStore(memory, key, rand.Reader, crypto.SHA256, tmpfile))
+
+	tmpfile.Seek(0, 0)
+	isok(t, xattr.VerifyStore(memory, tmpfile, opts))
+	tmpfile.Seek(0, 0)
+	assert(t, xattr.IsNotExist(xattr.VerifyStore(xattr.UserIMA, tmpfile, opts)))
+
+	// Copy the signature to a real xattr, without touching IMAAttrName.
+	value, err := memory.Get(tmpfile)
+	isok(t, err)
+	isok(t, xattr.UserIMA.Set(tmpfile, value))
+	tmpfile.Seek(0, 0)
+	isok(t, xattr.VerifyStore(xattr.UserIMA, tmpfile, opts))
+	assert(t, xattr.IMAAttrName == "security.ima")
+
+	// A changed file doesn't verify.
+	_, err = tmpfile.Write([]byte("evil"))
+	isok(t, err)
+	tmpfile.Seek(0, 0)
+	notok(t, xattr.VerifyStore(memory, tmpfile, opts))
+
+	// The MemoryStore follows the file, not the path.
+	renamed := tmpfile.Name() + ".renamed"
+	isok(t, os.Rename(tmpfile.Name(), renamed))
+	defer os.Remove(renamed)
+	fd, err := os.Open(renamed)
+	isok(t, err)
+	defer fd.Close()
+	read, err := memory.Get(fd)
+	isok(t, err)
+	assert(t, bytes.Equal(read, value))
+}
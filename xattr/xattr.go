@@ -19,27 +19,99 @@ import (
 	"io"
 	"os"
 
-	"golang.org/x/sys/unix"
-
 	"pault.ag/go/ima"
 )
 
 var (
-	// Files attribute to read and write IMA signatures to.
+	// Files attribute to read and write IMA signatures to. The functions
+	// that don't take a Store use an XattrStore with this Name; prefer
+	// passing a Store, such as IMA or UserIMA, to changing this.
 	IMAAttrName string = "security.ima"
 )
 
+// The XattrStore the functions that don't take a Store use.
+func defaultStore() XattrStore {
+	return XattrStore{Name: IMAAttrName}
+}
+
 // Load the ima signature from the filesystem xattr, and parse the Signature
 // into an ima.Signature block.
 //
 // If the attribute doesn't exist, golang/x/sys/unix.ENODATA will be returned.
 func Parse(fd *os.File) (*ima.Signature, error) {
-	data := make([]byte, 1024)
-	size, err := unix.Getxattr(fd.Name(), IMAAttrName, data)
+	data, err := Read(fd)
 	if err != nil {
 		return nil, err
 	}
-	return ima.Parse(data[:size])
+	return ima.Parse(data)
+}
+
+// Load the ima xattr from the filesystem, and parse it into whatever type
+// of ima.XattrValue is stored there. Unlike Parse, this will also return
+// values that aren't Signatures, such as a bare ima.Digest.
+//
+// If the attribute doesn't exist, golang/x/sys/unix.ENODATA will be returned.
+func ParseValue(fd *os.File) (ima.XattrValue, error) {
+	return ParseStore(defaultStore(), fd)
+}
+
+// Read the raw bytes of the ima xattr, such as to compare against a
+// serialized signature.
+//
+// The xattr is read from the open file descriptor, rather than by looking up
+// fd.Name() again, so it always comes from the same file that fd reads. This
+// also works for descriptors opened with O_PATH, which is handy to read the
+// xattr of a file without opening it for reading.
+//
+// If the attribute doesn't exist, golang/x/sys/unix.ENODATA will be returned.
+func Read(fd *os.File) ([]byte, error) {
+	return defaultStore().Get(fd)
+}
+
+// Read the raw bytes of the ima xattr of the file at path. If path is a
+// symlink, the xattr of the file it points to is read.
+//
+// Since the path is resolved again, prefer Read, with the file that was
+// measured, to check a signature.
+func ReadPath(path string) ([]byte, error) {
+	return defaultStore().GetPath(path)
+}
+
+// Read the raw bytes of the ima xattr of the file at path, without following
+// it if it's a symlink, the same as lgetxattr(2).
+func ReadLink(path string) ([]byte, error) {
+	return defaultStore().GetLink(path)
+}
+
+// Write a serialized ima xattr value, such as the output of ima.Sign, to the
+// file's xattr. The value must parse with ima.ParseXattr.
+//
+// Like Read, the xattr is written through the open file descriptor, which may
+// have been opened with O_PATH.
+func Write(fd *os.File, value []byte) error {
+	if _, err := ima.ParseXattr(value); err != nil {
+		return err
+	}
+	return defaultStore().Set(fd, value)
+}
+
+// Write a serialized ima xattr value to the file at path, following it if
+// it's a symlink. The value must parse with ima.ParseXattr.
+func WritePath(path string, value []byte) error {
+	if _, err := ima.ParseXattr(value); err != nil {
+		return err
+	}
+	return defaultStore().SetPath(path, value)
+}
+
+// Write a serialized ima xattr value to the file at path, without following
+// it if it's a symlink, the same as lsetxattr(2). The value must parse with
+// ima.ParseXattr.
+func WriteLink(path string, value []byte) error {
+	if _, err := ima.ParseXattr(value); err != nil {
+		return err
+	}
+	return defaultStore().SetLink(path, value)
 }
 
 // Load the ima signature from the filesystem xattr, and measure the file's
@@ -48,29 +120,21 @@ func Parse(fd *os.File) (*ima.Signature, error) {
 // and will either return nil for a valid signature from one of the keys, or
 // the last error for the last key tried.
 //
+// Legacy version 1 signatures don't record the algorithm the file was measured
+// with, so the file is measured with the same algorithm as the signature.
+//
 // This code expects the file is seek'd to the origin of the file, and will return
 // the file at its EOF.
 func Verify(fd *os.File, pool ima.KeyPool) error {
-	sig, err := Parse(fd)
-	if err != nil {
-		return err
-	}
-	hashFunc, err := sig.Header.Hash()
-	if err != nil {
-		return err
-	}
-	hash := hashFunc.New()
-	if _, err = io.Copy(hash, fd); err != nil {
-		return err
-	}
-	digest := hash.Sum(nil)
-
-	_, err = sig.Verify(ima.VerifyOptions{
-		Keys:   pool,
-		Digest: digest,
-		Hash:   *hashFunc,
-	})
-	return err
+	return VerifyWithOptions(fd, ima.VerifyOptions{Keys: pool})
+}
+
+// Verify the file the same way as Verify, but with control over the rest of
+// the ima.VerifyOptions, such as the CertificatePolicy, or a Blocklist of
+// revoked keys and file digests. The Digest and Hash are always replaced
+// with the measurement of the file.
+func VerifyWithOptions(fd *os.File, opts ima.VerifyOptions) error {
+	return VerifyStore(defaultStore(), fd, opts)
 }
 
 // Measure the file, and sign the digest with the provided signer. The entropy
@@ -80,14 +144,21 @@ func Verify(fd *os.File, pool ima.KeyPool) error {
 // This code expects the file is seek'd to the origin of the file, and will return
 // the file at its EOF.
 func Sign(signer crypto.Signer, rand io.Reader, opts crypto.SignerOpts, fd *os.File) error {
-	hash := opts.HashFunc().New()
-	if _, err := io.Copy(hash, fd); err != nil {
-		return err
+	return SignStore(defaultStore(), signer, rand, opts, fd)
+}
+
+// Measure the file, and return the serialized signature over its digest.
+func measureAndSign(signer crypto.Signer, rand io.Reader, opts crypto.SignerOpts, fd *os.File) ([]byte, error) {
+	imaHash, err := ima.SignerHash(opts)
+	if err != nil {
+		return nil, err
 	}
-	digest := hash.Sum(nil)
-	sig, err := ima.Sign(signer, rand, digest, opts)
+	hash, err := imaHash.New()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if _, err := io.Copy(hash, fd); err != nil {
+		return nil, err
 	}
-	return unix.Setxattr(fd.Name(), IMAAttrName, sig, 0x00)
+	return ima.Sign(signer, rand, hash.Sum(nil), opts)
 }
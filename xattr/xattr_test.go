@@ -15,22 +15,26 @@
 package xattr_test
 
 import (
+	"bytes"
+	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
-	_ "crypto/sha256"
+	"crypto/sha256"
+
+	"golang.org/x/sys/unix"
 
 	"pault.ag/go/ima"
+	"pault.ag/go/ima/sm2"
 	"pault.ag/go/ima/xattr"
 )
 
 func TestSign(t *testing.T) {
-	xattr.IMAAttrName = "user.ima"
-
 	key, err := rsa.GenerateKey(rand.Reader, 1024)
 	isok(t, err)
 
@@ -42,13 +46,152 @@ func TestSign(t *testing.T) {
 	isok(t, err)
 
 	tmpfile.Seek(0, 0)
-	isok(t, xattr.Sign(key, rand.Reader, crypto.SHA256, tmpfile))
+	isok(t, xattr.SignStore(xattr.UserIMA, key, rand.Reader, crypto.SHA256, tmpfile))
 	keys := ima.NewKeyPool()
 	isok(t, keys.AddKey(key.Public()))
 
 	tmpfile.Seek(0, 0)
-	isok(t, xattr.Verify(tmpfile, keys))
+	isok(t, xattr.VerifyStore(xattr.UserIMA, tmpfile, ima.VerifyOptions{Keys: keys}))
 	isok(t, tmpfile.Close())
+}
+
+func TestParseValue(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "ima-xattr")
+	isok(t, err)
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	digest := make([]byte, 2+sha256.Size)
+	digest[0] = ima.XattrDigestNG
+	digest[1] = ima.SHA256.Id
+	isok(t, xattr.UserIMA.SetPath(tmpfile.Name(), digest))
+
+	value, err := xattr.ParseStore(xattr.UserIMA, tmpfile)
+	isok(t, err)
+	_, ok := value.(*ima.Digest)
+	assert(t, ok)
+
+	data, err := xattr.UserIMA.Get(tmpfile)
+	isok(t, err)
+	_, err = ima.Parse(data)
+	notok(t, err)
+}
+
+func TestSignSM2(t *testing.T) {
+	key, err := sm2.GenerateKey(rand.Reader)
+	isok(t, err)
+
+	tmpfile, err := ioutil.TempFile("", "ima-xattr")
+	isok(t, err)
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+	_, err = tmpfile.Write([]byte("totally legit elf af"))
+	isok(t, err)
+
+	tmpfile.Seek(0, 0)
+	isok(t, xattr.SignStore(xattr.UserIMA, key, rand.Reader, ima.SignatureOptions{Hash: ima.SM3}, tmpfile))
+	keys := ima.NewKeyPool()
+	isok(t, keys.AddKey(key.Public()))
+
+	tmpfile.Seek(0, 0)
+	isok(t, xattr.VerifyStore(xattr.UserIMA, tmpfile, ima.VerifyOptions{Keys: keys}))
+}
+
+func TestVerifyBlocklist(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+
+	tmpfile, err := ioutil.TempFile("", "ima-xattr")
+	isok(t, err)
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+	_, err = tmpfile.Write([]byte("totally revoked elf af"))
+	isok(t, err)
+
+	tmpfile.Seek(0, 0)
+	isok(t, xattr.SignStore(xattr.UserIMA, key, rand.Reader, crypto.SHA256, tmpfile))
+	keys := ima.NewKeyPool()
+	isok(t, keys.AddKey(key.Public()))
+
+	digest := sha256.Sum256([]byte("totally revoked elf af"))
+	blocklist := ima.NewBlocklist()
+	blocklist.AddDigest(digest[:])
+
+	tmpfile.Seek(0, 0)
+	err = xattr.VerifyStore(xattr.UserIMA, tmpfile, ima.VerifyOptions{Keys: keys, Blocklist: blocklist})
+	assert(t, errors.Is(err, ima.RevokedDigest))
+}
+
+func TestDescriptor(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	keys := ima.NewKeyPool()
+	isok(t, keys.AddKey(key.Public()))
+
+	dir, err := ioutil.TempDir("", "ima-xattr")
+	isok(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "elf")
+	isok(t, ioutil.WriteFile(path, []byte("totally legit elf af"), 0644))
+
+	fd, err := os.Open(path)
+	isok(t, err)
+	defer fd.Close()
+
+	// Swap another file in under the same name after the file was opened.
+	isok(t, os.Rename(path, filepath.Join(dir, "moved")))
+	isok(t, ioutil.WriteFile(path, []byte("evil"), 0644))
+
+	isok(t, xattr.SignStore(xattr.UserIMA, key, rand.Reader, crypto.SHA256, fd))
+	_, err = xattr.UserIMA.GetPath(path)
+	assert(t, err == unix.ENODATA)
+	value, err := xattr.UserIMA.GetPath(filepath.Join(dir, "moved"))
+	isok(t, err)
+
+	read, err := xattr.UserIMA.Get(fd)
+	isok(t, err)
+	assert(t, bytes.Equal(read, value))
+	fd.Seek(0, 0)
+	isok(t, xattr.VerifyStore(xattr.UserIMA, fd, ima.VerifyOptions{Keys: keys}))
+
+	// And the same through an O_PATH descriptor.
+	opath, err := os.OpenFile(filepath.Join(dir, "moved"), unix.O_PATH, 0)
+	isok(t, err)
+	defer opath.Close()
+	read, err = xattr.UserIMA.Get(opath)
+	isok(t, err)
+	assert(t, bytes.Equal(read, value))
+	isok(t, xattr.UserIMA.Set(opath, value))
+
+	// Values that don't parse are refused before any xattr is written.
+	notok(t, xattr.Write(fd, []byte{0xff}))
+	notok(t, xattr.WritePath(path, []byte{0xff}))
+}
+
+func TestLink(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	digest := make([]byte, 32)
+	value, err := ima.Sign(key, rand.Reader, digest, crypto.SHA256)
+	isok(t, err)
+
+	dir, err := ioutil.TempDir("", "ima-xattr")
+	isok(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "elf")
+	link := filepath.Join(dir, "link")
+	isok(t, ioutil.WriteFile(path, []byte("totally legit elf af"), 0644))
+	isok(t, os.Symlink("elf", link))
+
+	isok(t, xattr.UserIMA.SetPath(link, value))
+	read, err := xattr.UserIMA.GetLink(path)
+	isok(t, err)
+	assert(t, bytes.Equal(read, value))
+	read, err = xattr.UserIMA.GetPath(link)
+	isok(t, err)
+	assert(t, bytes.Equal(read, value))
 
-	xattr.IMAAttrName = "security.ima"
+	// The symlink itself has no signature.
+	_, err = xattr.UserIMA.GetLink(link)
+	notok(t, err)
 }
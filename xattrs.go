@@ -0,0 +1,206 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ima
+
+import (
+	"crypto"
+	"fmt"
+)
+
+// Type bytes of the values that can be stored in the security.ima and
+// security.evm xattrs. The first byte of any such xattr is one of these,
+// and determines how the rest of the value is laid out.
+const (
+	// SHA1 digest of the file, with no hash algorithm byte
+	// (IMA_XATTR_DIGEST).
+	XattrDigest = 0x01
+
+	// SHA1 HMAC of the EVM protected metadata (EVM_XATTR_HMAC).
+	XattrHMAC = 0x02
+
+	// Signature stored in security.ima or security.evm
+	// (EVM_IMA_XATTR_DIGSIG).
+	XattrDigSig = 0x03
+
+	// Digest of the file, prefixed with the IMA EVM hash algorithm byte
+	// (IMA_XATTR_DIGEST_NG).
+	XattrDigestNG = 0x04
+
+	// EVM signature that doesn't include inode specific metadata, so
+	// it may be copied between filesystems (EVM_XATTR_PORTABLE_DIGSIG).
+	XattrPortableDigSig = 0x05
+
+	// Signature over an fs-verity file digest (IMA_VERITY_DIGSIG). These
+	// are always version 3 signatures.
+	XattrVerityDigSig = 0x06
+)
+
+// Any of the values that may be stored in an IMA or EVM xattr. The concrete
+// type will be one of *Signature, *SignatureV1, *Digest or *HMAC, which can
+// be picked apart with a type switch.
+type XattrValue interface {
+	// Return the xattr type byte this value is stored with.
+	XattrType() uint8
+}
+
+// Return the xattr type byte of the Signature, which is the Magic in the
+// Signature Header.
+func (s Signature) XattrType() uint8 {
+	return s.Header.Magic
+}
+
+// Bare file digest, as written by the kernel when booted with
+// ima_appraise=fix, or by `evmctl ima_hash`. There is no way to validate who
+// created this digest, it only asserts what the file contents were at the
+// time it was set.
+type Digest struct {
+	// Either XattrDigest, where the HashAlgorithm is implied to be SHA1, or
+	// XattrDigestNG.
+	Type uint8
+
+	// IMA EVM Hash algorithm of the Digest.
+	HashAlgorithm uint8
+
+	// Digest of the file.
+	Digest []byte
+}
+
+// Return the xattr type byte of the Digest.
+func (d Digest) XattrType() uint8 {
+	return d.Type
+}
+
+// Get the native Go crypto.Hash used to compute the Digest.
+func (d Digest) Hash() (*crypto.Hash, error) {
+	return HashFunctions.ToCrypto(d.HashAlgorithm)
+}
+
+// EVM HMAC over the security metadata of a file. This is computed with a
+// key only known to the kernel, so this library can't verify it, but it
+// is exposed so that tools can tell what's set.
+type HMAC struct {
+	// SHA1 HMAC value.
+	Digest []byte
+}
+
+// Return the xattr type byte of the HMAC, which is always XattrHMAC.
+func (h HMAC) XattrType() uint8 {
+	return XattrHMAC
+}
+
+// Take the bytes of an IMA or EVM xattr, and return a typed XattrValue.
+// Signatures are parsed with Parse, so the same rules apply.
+func ParseXattr(data []byte) (XattrValue, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("ima: empty xattr value")
+	}
+
+	switch data[0] {
+	case XattrDigest:
+		if len(data[1:]) != SHA1.Hash.Size() {
+			return nil, fmt.Errorf(
+				"ima: expected digest length of %d, got %d",
+				SHA1.Hash.Size(),
+				len(data[1:]),
+			)
+		}
+		return &Digest{
+			Type:          XattrDigest,
+			HashAlgorithm: SHA1.Id,
+			Digest:        append([]byte{}, data[1:]...),
+		}, nil
+	case XattrDigestNG:
+		if len(data) < 2 {
+			return nil, fmt.Errorf("ima: digest is missing the hash algorithm")
+		}
+		imaHash, err := HashFunctions.Lookup(data[1])
+		if err != nil {
+			return nil, err
+		}
+		if len(data[2:]) != imaHash.Size {
+			return nil, fmt.Errorf(
+				"ima: expected %s digest length of %d, got %d",
+				imaHash.Name,
+				imaHash.Size,
+				len(data[2:]),
+			)
+		}
+		return &Digest{
+			Type:          XattrDigestNG,
+			HashAlgorithm: data[1],
+			Digest:        append([]byte{}, data[2:]...),
+		}, nil
+	case XattrHMAC:
+		if len(data[1:]) != SHA1.Hash.Size() {
+			return nil, fmt.Errorf(
+				"ima: expected hmac length of %d, got %d",
+				SHA1.Hash.Size(),
+				len(data[1:]),
+			)
+		}
+		return &HMAC{Digest: append([]byte{}, data[1:]...)}, nil
+	case XattrDigSig, XattrPortableDigSig, XattrVerityDigSig:
+		if len(data) > 1 && data[1] == SignatureVersion1 {
+			return ParseV1(data)
+		}
+		return Parse(data)
+	default:
+		return nil, fmt.Errorf("ima: unknown xattr type %x", data[0])
+	}
+}
+
+// Take an XattrValue, and convert it to a byte array suitable to be written
+// to an IMA or EVM xattr.
+func SerializeXattr(value XattrValue) ([]byte, error) {
+	switch v := value.(type) {
+	case Signature:
+		return Serialize(v)
+	case *Signature:
+		return Serialize(*v)
+	case Digest:
+		return serializeDigest(v)
+	case *Digest:
+		return serializeDigest(*v)
+	case SignatureV1, *SignatureV1:
+		return nil, fmt.Errorf("ima: version 1 signatures can't be serialized")
+	case HMAC:
+		return serializeHMAC(v)
+	case *HMAC:
+		return serializeHMAC(*v)
+	default:
+		return nil, fmt.Errorf("ima: unknown xattr value %T", value)
+	}
+}
+
+func serializeDigest(d Digest) ([]byte, error) {
+	switch d.Type {
+	case XattrDigest:
+		if d.HashAlgorithm != SHA1.Id || len(d.Digest) != SHA1.Hash.Size() {
+			return nil, fmt.Errorf("ima: legacy digests must be SHA1")
+		}
+		return append([]byte{XattrDigest}, d.Digest...), nil
+	case XattrDigestNG:
+		return append([]byte{XattrDigestNG, d.HashAlgorithm}, d.Digest...), nil
+	default:
+		return nil, fmt.Errorf("ima: digest xattr type %x is not a digest", d.Type)
+	}
+}
+
+func serializeHMAC(h HMAC) ([]byte, error) {
+	if len(h.Digest) != SHA1.Hash.Size() {
+		return nil, fmt.Errorf("ima: hmacs must be SHA1")
+	}
+	return append([]byte{XattrHMAC}, h.Digest...), nil
+}
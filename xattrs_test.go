@@ -0,0 +1,118 @@
+// Copyright 2017 Paul Tagliamonte <paultag@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ima_test
+
+import (
+	"bytes"
+	"testing"
+
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+
+	"pault.ag/go/ima"
+)
+
+func TestParseXattrDigest(t *testing.T) {
+	digest := sha1.Sum([]byte("Totally real ELF no tricks"))
+
+	value, err := ima.ParseXattr(append([]byte{0x01}, digest[:]...))
+	isok(t, err)
+	imaDigest, ok := value.(*ima.Digest)
+	assert(t, ok)
+	assert(t, imaDigest.XattrType() == ima.XattrDigest)
+	assert(t, imaDigest.HashAlgorithm == ima.SHA1.Id)
+	assert(t, bytes.Compare(imaDigest.Digest, digest[:]) == 0)
+
+	_, err = ima.ParseXattr([]byte{0x01, 0x02, 0x03})
+	notok(t, err)
+}
+
+func TestXattrDigestNGRoundTrip(t *testing.T) {
+	digest := sha256.Sum256([]byte("Totally real ELF no tricks"))
+	data := append([]byte{0x04, 0x04}, digest[:]...)
+
+	value, err := ima.ParseXattr(data)
+	isok(t, err)
+	imaDigest, ok := value.(*ima.Digest)
+	assert(t, ok)
+	assert(t, imaDigest.XattrType() == ima.XattrDigestNG)
+	assert(t, imaDigest.HashAlgorithm == ima.SHA256.Id)
+
+	buf, err := ima.SerializeXattr(value)
+	isok(t, err)
+	assert(t, bytes.Compare(buf, data) == 0)
+}
+
+func TestParseXattrDigestNGInvalid(t *testing.T) {
+	digest := sha256.Sum256([]byte("Totally real ELF no tricks"))
+
+	for _, data := range [][]byte{
+		{0x04},
+		{0x04, 0x04},
+		{0x04, 0x04, 0xaa, 0xbb, 0xcc},
+		append([]byte{0x04, 0x04}, digest[1:]...),
+		append(append([]byte{0x04, 0x04}, digest[:]...), 0x00),
+		append([]byte{0x04, 0x06}, digest[:]...),
+		append([]byte{0x04, 0xff}, digest[:]...),
+	} {
+		_, err := ima.ParseXattr(data)
+		notok(t, err)
+	}
+}
+
+func TestXattrHMACRoundTrip(t *testing.T) {
+	data := append([]byte{0x02}, bytes.Repeat([]byte{0xAA}, 20)...)
+
+	value, err := ima.ParseXattr(data)
+	isok(t, err)
+	_, ok := value.(*ima.HMAC)
+	assert(t, ok)
+	assert(t, value.XattrType() == ima.XattrHMAC)
+
+	buf, err := ima.SerializeXattr(value)
+	isok(t, err)
+	assert(t, bytes.Compare(buf, data) == 0)
+}
+
+func TestParseXattrSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	isok(t, err)
+	digest := sha256.Sum256([]byte("Totally real ELF no tricks"))
+
+	sigBytes, err := ima.Sign(key, rand.Reader, digest[:], ima.SignatureOptions{
+		Hash: ima.SHA256,
+		Type: ima.XattrPortableDigSig,
+	})
+	isok(t, err)
+
+	value, err := ima.ParseXattr(sigBytes)
+	isok(t, err)
+	sig, ok := value.(*ima.Signature)
+	assert(t, ok)
+	assert(t, sig.XattrType() == ima.XattrPortableDigSig)
+
+	buf, err := ima.SerializeXattr(sig)
+	isok(t, err)
+	assert(t, bytes.Compare(buf, sigBytes) == 0)
+}
+
+func TestParseXattrUnknown(t *testing.T) {
+	_, err := ima.ParseXattr([]byte{0x07, 0x00})
+	notok(t, err)
+	_, err = ima.ParseXattr([]byte{})
+	notok(t, err)
+}